@@ -6,7 +6,8 @@ import (
 )
 
 var (
-	ErrorWaiterNotFound = errors.New("Waiter not found")
+	ErrorWaiterNotFound  = errors.New("Waiter not found")
+	ErrorAckDisconnected = errors.New("disconnected before ack")
 )
 
 /**
@@ -33,7 +34,7 @@ func (a *ackProcessor) getNextId() int {
 Just before the ack function called, the waiter should be added
 to wait and receive response to ack call
 */
-func (a *ackProcessor) addWaiter(id int, w chan interface{}) {
+func (a *ackProcessor) addWaiter(id int, w *AckFuture) {
 	a.resultWaitersMap.Store(id, w)
 }
 
@@ -47,9 +48,31 @@ func (a *ackProcessor) removeWaiter(id int) {
 /**
 check if waiter with given ack id is exists, and returns it
 */
-func (a *ackProcessor) getWaiter(id int) (chan interface{}, error) {
+func (a *ackProcessor) getWaiter(id int) (*AckFuture, error) {
 	if waiter, ok := a.resultWaitersMap.Load(id); ok {
-		return waiter.(chan interface{}), nil
+		return waiter.(*AckFuture), nil
 	}
 	return nil, ErrorWaiterNotFound
 }
+
+/**
+removes waiter and completes it with the response, never blocks.
+Late responses of removed waiters are dropped
+*/
+func (a *ackProcessor) resolve(id int, result []interface{}) {
+	if waiter, ok := a.resultWaitersMap.LoadAndDelete(id); ok {
+		waiter.(*AckFuture).resolve(result)
+	}
+}
+
+/**
+fails all pending waiters, called when the channel is closed
+*/
+func (a *ackProcessor) failAll(err error) {
+	a.resultWaitersMap.Range(func(id, waiter interface{}) bool {
+		if _, ok := a.resultWaitersMap.LoadAndDelete(id); ok {
+			waiter.(*AckFuture).fail(err)
+		}
+		return true
+	})
+}
@@ -0,0 +1,158 @@
+package shadiaosocketio
+
+import (
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"reflect"
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+/*
+*
+Error returned by handler, sent back in ack as {"error":{"code":"...","message":"..."}}
+by default. Handlers may return it to set the code
+*/
+type AckError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *AckError) Error() string {
+	return e.Message
+}
+
+/*
+*
+Converts error returned by handler to ack value
+*/
+type AckErrorEncoder func(err error) interface{}
+
+/*
+*
+Converts json of the first ack value to AckError, nil if the ack is not an error
+*/
+type AckErrorDecoder func(data []byte) *AckError
+
+type ackErrorResult struct {
+	Error *AckError `json:"error"`
+}
+
+func toAckError(err error) *AckError {
+	ackErr := &AckError{}
+	if errors.As(err, &ackErr) {
+		return ackErr
+	}
+
+	return &AckError{Message: err.Error()}
+}
+
+func defaultAckErrorEncoder(err error) interface{} {
+	return &ackErrorResult{Error: toAckError(err)}
+}
+
+func defaultAckErrorDecoder(data []byte) *AckError {
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+
+	result := &ackErrorResult{}
+	if utils.Json.Unmarshal(data, result) != nil || result.Error == nil {
+		return nil
+	}
+	if result.Error.Code == "" && result.Error.Message == "" {
+		return nil
+	}
+
+	return result.Error
+}
+
+/*
+*
+Set shape of error acks, sent when handler returns non-nil trailing error
+and recognized by Ack and EmitAck. Both sides should use the same shape
+*/
+func (m *methods) SetAckErrorCodec(encode AckErrorEncoder, decode AckErrorDecoder) {
+	m.ackErrorEncoder = encode
+	m.ackErrorDecoder = decode
+}
+
+func (m *methods) encodeAckError(err error) interface{} {
+	if m.ackErrorEncoder != nil {
+		return m.ackErrorEncoder(err)
+	}
+
+	return defaultAckErrorEncoder(err)
+}
+
+/*
+*
+Answer ack of the event with the error instead of handler results
+*/
+func (m *methods) sendAckError(c *Channel, ackId int, err error) {
+	res := &protocol.Message{
+		Type:  protocol.ACK,
+		Nsp:   protocol.DefaultNsp,
+		AckId: ackId,
+		Args:  []interface{}{m.encodeAckError(err)},
+	}
+
+	c.sendPacket(protocol.GetMsgPacket(res))
+}
+
+func (m *methods) decodeAckError(data []byte) *AckError {
+	if m.ackErrorDecoder != nil {
+		return m.ackErrorDecoder(data)
+	}
+
+	return defaultAckErrorDecoder(data)
+}
+
+/*
+*
+Convert handler results to ack args, non-nil trailing error replaces the results
+*/
+func (m *methods) ackArgs(f *caller, res []reflect.Value) []interface{} {
+	if f.ErrorOut {
+		if err, _ := res[len(res)-1].Interface().(error); err != nil {
+			return []interface{}{m.encodeAckError(err)}
+		}
+		res = res[:len(res)-1]
+	}
+
+	arr := make([]interface{}, 0, len(res))
+	for _, v := range res {
+		arr = append(arr, v.Interface())
+	}
+
+	return arr
+}
+
+/*
+*
+Check if the first value of ack result is an error, values are raw json
+in text mode and decoded values in binary mode
+*/
+func (m *methods) ackResultError(values []interface{}) (*AckError, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	data, err := ackValueJson(values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return m.decodeAckError(data), nil
+}
+
+func ackValueJson(v interface{}) ([]byte, error) {
+	if data, ok := v.([]byte); ok {
+		return data, nil
+	}
+
+	return utils.Json.Marshal(v)
+}
@@ -0,0 +1,75 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"errors"
+)
+
+/*
+*
+Answers of broadcast with ack, keyed by socket id. Sockets which haven't answered
+before the context was done or disconnected are listed in Missing
+*/
+type BroadcastAck struct {
+	Responses map[string][]interface{}
+	Errors    map[string]*AckError
+	Missing   []string
+}
+
+/*
+*
+Emit event with ack to every channel of the room and wait for the answers until ctx
+is done or all channels answered. ctx should have a deadline
+*/
+func (s *Server) BroadcastToWithAck(ctx context.Context, room, method string, args ...interface{}) *BroadcastAck {
+	return broadcastWithAck(ctx, s.List(room), nil, method, args...)
+}
+
+/*
+*
+Same as Server.BroadcastToWithAck, but the channel itself is excluded
+*/
+func (c *Channel) BroadcastToWithAck(ctx context.Context, room, method string, args ...interface{}) *BroadcastAck {
+	if c.server == nil {
+		return broadcastWithAck(ctx, nil, c, method, args...)
+	}
+
+	return broadcastWithAck(ctx, c.server.List(room), c, method, args...)
+}
+
+func broadcastWithAck(ctx context.Context, channels []*Channel, except *Channel,
+	method string, args ...interface{}) *BroadcastAck {
+
+	res := &BroadcastAck{
+		Responses: make(map[string][]interface{}),
+		Errors:    make(map[string]*AckError),
+		Missing:   make([]string, 0),
+	}
+
+	ids := make([]string, 0, len(channels))
+	futures := make([]*AckFuture, 0, len(channels))
+	for _, cn := range channels {
+		if cn == except || !cn.IsAlive() {
+			continue
+		}
+
+		ids = append(ids, cn.Id())
+		futures = append(futures, cn.EmitWithAck(ctx, method, args...))
+	}
+
+	for i, f := range futures {
+		result, err := f.Wait()
+
+		ackErr := &AckError{}
+		switch {
+		case err == nil:
+			res.Responses[ids[i]] = result
+		case errors.As(err, &ackErr):
+			res.Errors[ids[i]] = ackErr
+		default:
+			res.Missing = append(res.Missing, ids[i])
+		}
+	}
+
+	return res
+}
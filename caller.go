@@ -1,6 +1,8 @@
 package shadiaosocketio
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"reflect"
@@ -10,11 +12,34 @@ type caller struct {
 	Func   reflect.Value
 	NumInt int
 	NumOut int
+
+	// NumArgs is the amount of event args without the variadic one
+	NumArgs  int
+	Variadic bool
+
+	// Parallel handlers are not waiting for previous events of the channel
+	Parallel bool
+	// Context is set for handlers with context.Context as the first arg
+	Context bool
+	// ErrorOut is set for handlers with error as the last result
+	ErrorOut bool
 }
 
 var (
-	ErrorCallerNotFunc       = errors.New("f is not function")
-	ErrorCallerMaxFiveArgs   = errors.New("f maximum number of args is 5")
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	channelType    = reflect.TypeOf((*Channel)(nil))
+)
+
+var (
+	ErrorCallerNotFunc = errors.New("f is not function")
+	ErrorCallerChannel = errors.New("f must accept *Channel as the first arg or after context.Context")
+	ErrorCallerContext = errors.New("f may accept context.Context only as the first arg")
+	ErrorArgsMismatch  = errors.New("wrong number of arguments")
+
+	// Deprecated: handlers have no limit of args anymore
+	ErrorCallerMaxFiveArgs = errors.New("f maximum number of args is 5")
+	// Deprecated: handlers have no limit of values anymore
 	ErrorCallerMaxFiveValues = errors.New("f maximum number of values is 5")
 )
 
@@ -30,56 +55,153 @@ func newCaller(f interface{}) (*caller, error) {
 	}
 
 	fType := fVal.Type()
-	if fType.NumOut() > 5 {
-		panic(ErrorCallerMaxFiveValues)
+	curCaller := &caller{
+		Func:     fVal,
+		NumInt:   fType.NumIn(),
+		NumOut:   fType.NumOut(),
+		Variadic: fType.IsVariadic(),
+		Context:  fType.NumIn() > 0 && fType.In(0) == contextType,
+
+		ErrorOut: fType.NumOut() > 0 && fType.Out(fType.NumOut()-1) == errorType,
 	}
-	if fType.NumIn() > 5 {
-		panic(ErrorCallerMaxFiveArgs)
+
+	// event args follow the context and the channel, variadic one is not counted
+	first := 0
+	if curCaller.Context {
+		first++
+	}
+	if curCaller.NumInt <= first || (curCaller.Variadic && curCaller.NumInt == first+1) ||
+		!channelType.AssignableTo(fType.In(first)) {
+		return nil, ErrorCallerChannel
+	}
+	for i := first + 1; i < curCaller.NumInt; i++ {
+		if fType.In(i) == contextType {
+			return nil, ErrorCallerContext
+		}
 	}
 
-	curCaller := &caller{
-		Func:   fVal,
-		NumInt: fType.NumIn(),
-		NumOut: fType.NumOut(),
+	curCaller.NumArgs = curCaller.NumInt - first - 1
+	if curCaller.Variadic {
+		curCaller.NumArgs--
 	}
 
 	return curCaller, nil
 }
 
-func (c *caller) getArgType(index int) interface{} {
-	return reflect.New(c.Func.Type().In(index)).Interface()
-}
-
 func (c *caller) getOutType(index int) interface{} {
 	return reflect.New(c.Func.Type().Out(index)).Interface()
 }
 
-func (c *caller) callFunc(h *Channel, argsType int, args ...interface{}) []reflect.Value {
+/*
+*
+Put context and channel to the args, returns index of the first event arg
+*/
+func (c *caller) prepareArgs(ctx context.Context, h *Channel) ([]reflect.Value, int) {
 	arr := make([]reflect.Value, 0, 1+c.NumInt)
+	if c.Context {
+		arr = append(arr, reflect.ValueOf(&ctx).Elem())
+	}
 	arr = append(arr, reflect.ValueOf(h))
 
-	for i := 0; i < c.NumInt-1; i++ { // * 1 2   // x{0} y{1}
-		data := c.getArgType(i + 1)
+	return arr, len(arr)
+}
+
+/*
+*
+Call function with decoded values, every value is converted to the type of the argument
+*/
+func (c *caller) callFunc(ctx context.Context, h *Channel, args ...interface{}) []reflect.Value {
+	fType := c.Func.Type()
+	arr, first := c.prepareArgs(ctx, h)
+
+	for i := 0; i < c.NumArgs; i++ { // * 1 2   // x{0} y{1}
+		argType := fType.In(i + first)
 
 		if i > len(args)-1 {
-			arr = append(arr, reflect.ValueOf(data).Elem())
+			arr = append(arr, reflect.Zero(argType))
 			continue
 		}
 
-		var marshal []byte
-		if argsType == 0 {
-			marshal, _ = utils.Json.Marshal(args[i])
-		} else {
-			marshal = args[i].([]byte)
+		arr = append(arr, decodeValue(argType, args[i]))
+	}
+
+	if c.Variadic {
+		elemType := fType.In(fType.NumIn() - 1).Elem()
+		for i := c.NumArgs; i < len(args); i++ {
+			arr = append(arr, decodeValue(elemType, args[i]))
 		}
+	}
+
+	return c.Func.Call(arr)
+}
 
-		err := utils.Json.Unmarshal(marshal, &data)
-		if err != nil {
-			panic(err)
+/*
+*
+Call function with raw json values, json.RawMessage arguments get the slices as is
+*/
+func (c *caller) callRaw(ctx context.Context, h *Channel, args []json.RawMessage) []reflect.Value {
+	fType := c.Func.Type()
+	arr, first := c.prepareArgs(ctx, h)
+
+	for i := 0; i < c.NumArgs; i++ {
+		argType := fType.In(i + first)
+
+		if i > len(args)-1 {
+			arr = append(arr, reflect.Zero(argType))
+			continue
 		}
 
-		arr = append(arr, reflect.ValueOf(data).Elem())
+		arr = append(arr, decodeRaw(argType, args[i]))
+	}
+
+	if c.Variadic {
+		elemType := fType.In(fType.NumIn() - 1).Elem()
+		for i := c.NumArgs; i < len(args); i++ {
+			arr = append(arr, decodeRaw(elemType, args[i]))
+		}
 	}
 
 	return c.Func.Call(arr)
 }
+
+/*
+*
+Checks if amount of event args matches the signature
+*/
+func (c *caller) acceptsArgs(n int) bool {
+	if n < c.NumArgs {
+		return false
+	}
+
+	return n == c.NumArgs || c.Variadic
+}
+
+func decodeValue(argType reflect.Type, arg interface{}) reflect.Value {
+	// values of the arg type are passed as is, e.g. errors of system events
+	if v := reflect.ValueOf(arg); v.IsValid() && v.Type().AssignableTo(argType) {
+		return v
+	}
+
+	marshal, _ := utils.Json.Marshal(arg)
+	data := reflect.New(argType)
+	err := utils.Json.Unmarshal(marshal, data.Interface())
+	if err != nil {
+		panic(err)
+	}
+
+	return data.Elem()
+}
+
+func decodeRaw(argType reflect.Type, arg json.RawMessage) reflect.Value {
+	if argType == rawMessageType {
+		return reflect.ValueOf(arg)
+	}
+
+	data := reflect.New(argType)
+	err := utils.Json.Unmarshal(arg, data.Interface())
+	if err != nil {
+		panic(err)
+	}
+
+	return data.Elem()
+}
@@ -0,0 +1,40 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewCaller(t *testing.T) {
+	tests := []struct {
+		name    string
+		f       interface{}
+		err     error
+		numArgs int
+	}{
+		{"channel", func(c *Channel) {}, nil, 0},
+		{"args", func(c *Channel, a string, b int) {}, nil, 2},
+		{"variadic", func(c *Channel, a string, b ...int) {}, nil, 1},
+		{"context", func(ctx context.Context, c *Channel, a string) {}, nil, 1},
+		{"interface", func(c interface{}, a string) {}, nil, 1},
+		{"not func", "f", ErrorCallerNotFunc, 0},
+		{"no args", func() {}, ErrorCallerChannel, 0},
+		{"no channel", func(a string) {}, ErrorCallerChannel, 0},
+		{"channel second", func(a string, c *Channel) {}, ErrorCallerChannel, 0},
+		{"context only", func(ctx context.Context) {}, ErrorCallerChannel, 0},
+		{"context second", func(c *Channel, ctx context.Context, a string) {}, ErrorCallerContext, 0},
+		{"variadic channel", func(c ...*Channel) {}, ErrorCallerChannel, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newCaller(tt.f)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && c.NumArgs != tt.numArgs {
+				t.Errorf("NumArgs = %d, want %d", c.NumArgs, tt.numArgs)
+			}
+		})
+	}
+}
@@ -1,38 +1,41 @@
 package shadiaosocketio
 
 import (
+	"context"
 	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/engineio"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
-	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
 	"net"
 	"net/http"
+	"net/netip"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
-const (
-	queueBufferSize = 10000
-)
 const (
 	DefaultCloseTxt  = "transport close"
 	DefaultCloseCode = 101
 )
 
+// namespace connection states of server channels
+const (
+	channelIdle int32 = iota
+	channelConnecting
+	channelConnected
+	channelClosed
+)
+
 var (
-	ErrorWrongHeader = errors.New("Wrong header")
+	ErrorWrongHeader  = errors.New("Wrong header")
+	ErrorNotConnected = errors.New("event before connect")
 )
 
 /*
 *
 engine.io header to send or receive
 */
-type Header struct {
-	Sid          string   `json:"sid"`
-	Upgrades     []string `json:"upgrades"`
-	PingInterval int      `json:"pingInterval"`
-	PingTimeout  int      `json:"pingTimeout"`
-}
+type Header = engineio.Header
 
 /*
 *
@@ -45,15 +48,28 @@ Close message means channel is closed
 ping is automatic
 */
 type Channel struct {
-	conn *websocket.Connection
+	socket *engineio.Socket
+	sid    string
+
+	ack ackProcessor
 
-	out    chan interface{}
-	header Header
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	alive     bool
-	aliveLock sync.Mutex
+	methods *methods
+	data    Data
 
-	ack ackProcessor
+	handshake  Handshake
+	clientAddr netip.Addr
+	proxyChain []string
+	connected  int32
+
+	inFlight     chan struct{}
+	inFlightOnce sync.Once
+
+	serial        []func()
+	serialLock    sync.Mutex
+	serialRunning bool
 
 	server  *Server
 	ip      string
@@ -61,34 +77,44 @@ type Channel struct {
 }
 
 func (c *Channel) BinaryMessage() bool {
-	return c.conn.GetUseBinaryMessage()
+	return c.socket.Transport().GetUseBinaryMessage()
 }
 
 func (c *Channel) RemoteAddr() net.Addr {
-	return c.conn.RemoteAddr()
+	return c.socket.RemoteAddr()
 }
 
 func (c *Channel) LocalAddr() net.Addr {
-	return c.conn.LocalAddr()
+	return c.socket.LocalAddr()
 }
 
-func (c *Channel) initChannel() {
-	//TODO: queueBufferSize from constant to server or socket variable
-	c.out = make(chan interface{}, queueBufferSize)
-	//c.ack.resultWaiters = make(map[int](chan string))
-	c.setAliveValue(true)
+/*
+*
+Bind channel to engine.io socket
+*/
+func (c *Channel) initChannel(s *engineio.Socket, m *methods) {
+	c.socket = s
+	c.methods = m
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	s.OnMessage(func(s *engineio.Socket, data []byte, binary bool) {
+		m.processIncomingMessage(c, data, binary)
+	})
+	s.OnClose(func(s *engineio.Socket, reason error) {
+		closeChannel(c, m, reason)
+	})
 }
 
 func (c *Channel) Id() string {
-	return c.header.Sid
+	return c.sid
 }
 
 func (c *Channel) ReadBytes() int {
-	return c.conn.GetReadBytes()
+	return c.socket.ReadBytes()
 }
 
 func (c *Channel) WriteBytes() int {
-	return c.conn.GetWriteBytes()
+	return c.socket.WriteBytes()
 }
 
 /*
@@ -96,143 +122,111 @@ func (c *Channel) WriteBytes() int {
 Checks that Channel is still alive
 */
 func (c *Channel) IsAlive() bool {
-	c.aliveLock.Lock()
-	isAlive := c.alive
-	c.aliveLock.Unlock()
-
-	return isAlive
-}
+	if c.socket == nil {
+		return false
+	}
 
-func (c *Channel) setAliveValue(value bool) {
-	c.aliveLock.Lock()
-	c.alive = value
-	c.aliveLock.Unlock()
+	return c.socket.IsAlive()
 }
 
 /*
 *
-Close channel
+Send socket.io packet, encoded according to the transport settings
 */
-func closeChannel(c *Channel, m *methods, args ...interface{}) error {
-	if !c.IsAlive() {
-		//already closed
-		return nil
+func (c *Channel) sendPacket(packet *protocol.MsgPack) error {
+	if !c.BinaryMessage() {
+		return c.socket.Send(protocol.EncodeText(packet))
 	}
-	c.setAliveValue(false)
 
-	var s []interface{}
-	closeErr := &websocket.CloseError{}
+	data, err := protocol.EncodeBinary(packet)
+	if err != nil {
+		return err
+	}
 
-	if len(args) == 0 {
-		closeErr.Code = DefaultCloseCode
-		closeErr.Text = DefaultCloseTxt
+	return c.socket.SendBinary(data)
+}
 
-		s = append(s, closeErr)
-	} else {
-		s = append(s, args...)
+/*
+*
+Send packet, waits for room in outgoing queue until ctx is done
+*/
+func (c *Channel) sendPacketContext(ctx context.Context, packet *protocol.MsgPack) error {
+	if !c.BinaryMessage() {
+		return c.socket.SendContext(ctx, protocol.EncodeText(packet))
 	}
 
-	c.conn.Close()
-	c.out <- protocol.CloseMsg
+	data, err := protocol.EncodeBinary(packet)
+	if err != nil {
+		return err
+	}
 
-	m.callLoopEvent(c, OnDisconnection, s...)
+	return c.socket.SendBinaryContext(ctx, data)
+}
 
-	return nil
+/*
+*
+Get amount of packets waiting in outgoing queue
+*/
+func (c *Channel) Queued() int {
+	return c.socket.Queued()
 }
 
-// incoming messages loop, puts incoming messages to In channel
-func inLoop(c *Channel, m *methods) error {
-	for {
-		msg, err := c.conn.GetMessage()
-		if err != nil {
-			return closeChannel(c, m, err)
+/*
+*
+Set outgoing queue watermarks, onHigh is called when the queue grows to high,
+onLow is called when it's drained to low afterwards
+*/
+func (c *Channel) SetWatermarks(high, low int, onHigh, onLow func(c *Channel)) {
+	c.socket.SetWatermarks(high, low, func(s *engineio.Socket, high bool) {
+		if high && onHigh != nil {
+			onHigh(c)
 		}
-		prefix := string(msg[0])
-		protocolV := c.conn.GetProtocol()
-
-		switch prefix {
-		case protocol.OpenMsg:
-			if err := utils.Json.UnmarshalFromString(msg[1:], &c.header); err != nil {
-				closeErr := &websocket.CloseError{}
-				closeErr.Code = websocket.ParseOpenMsgCode
-				closeErr.Text = err.Error()
-
-				return closeChannel(c, m, closeErr)
-			}
-
-			if protocolV == protocol.Protocol3 {
-				m.callLoopEvent(c, OnConnection)
-				// in protocol v3, the client sends a ping, and the server answers with a pong
-				go SchedulePing(c)
-			}
-			if c.conn.GetProtocol() == protocol.Protocol4 {
-				// in protocol v4 & binary msg Connection to a namespace
-				if c.conn.GetUseBinaryMessage() {
-					c.out <- &protocol.MsgPack{
-						Type: protocol.CONNECT,
-						Nsp:  protocol.DefaultNsp,
-						Data: &struct {
-						}{},
-					}
-					// in protocol v4 & text msg Connection to a namespace
-				} else {
-					c.out <- protocol.CommonMsg + protocol.OpenMsg
-				}
-			}
-		case protocol.CloseMsg:
-			return closeChannel(c, m)
-		case protocol.PingMsg:
-			// in protocol v4, the server sends a ping, and the client answers with a pong
-			c.out <- protocol.PongMsg
-		case protocol.PongMsg:
-		case protocol.UpgradeMsg:
-		case protocol.CommonMsg:
-			// in protocol v3 & binary msg  ps: 4{"type":0,"data":null,"nsp":"/","id":0}
-			// in protocol v3 & text msg  ps: 40 or 41 or 42["message", ...]
-			// in protocol v4 & text msg  ps: 40 or 41 or 42["message", ...]
-			go m.processIncomingMessage(c, msg[1:])
-		default:
-			// in protocol v4 & binary msg ps: {"type":0,"data":{"sid":"HWEr440000:1:R1CHyink:shadiao:101"},"nsp":"/","id":0}
-			go m.processIncomingMessage(c, msg)
+		if !high && onLow != nil {
+			onLow(c)
 		}
-	}
+	})
 }
 
-func outLoop(c *Channel, m *methods) error {
-	for {
-		outBufferLen := len(c.out)
-		if outBufferLen >= queueBufferSize-1 {
-			closeErr := &websocket.CloseError{}
-			closeErr.Code = websocket.QueueBufferSizeCode
-			closeErr.Text = ErrorSocketOverflood.Error()
+/*
+*
+Channel closed by engine.io socket, fire disconnection event.
+Data of the channel is available to disconnection handlers and cleared after them.
+It's fired only for connected channels, on the server after pending OnConnection
+*/
+func closeChannel(c *Channel, m *methods, reason error) {
+	c.cancel()
+	c.ack.failAll(ErrorAckDisconnected)
 
-			closeChannel(c, m, closeErr)
-		}
+	var s []interface{}
 
-		msg := <-c.out
-		if msg == protocol.CloseMsg {
-			return nil
-		}
+	if reason == nil {
+		closeErr := &websocket.CloseError{}
+		closeErr.Code = DefaultCloseCode
+		closeErr.Text = DefaultCloseTxt
 
-		err := c.conn.WriteMessage(msg)
-		if err != nil {
-			closeErr := &websocket.CloseError{}
-			closeErr.Code = websocket.WriteBufferErrCode
-			closeErr.Text = err.Error()
+		s = append(s, closeErr)
+	} else {
+		s = append(s, reason)
+	}
 
-			closeChannel(c, m, closeErr)
-		}
+	// panic of disconnection handler is reported like the one of any other event
+	task := func() {
+		defer c.data.Clear()
+		defer m.recoverEvent(c, OnDisconnection, -1, nil)
+		m.callLoopEvent(c, OnDisconnection, s...)
 	}
-}
 
-func SchedulePing(c *Channel) {
-	interval, _ := c.conn.PingParams()
-	ticker := time.NewTicker(interval)
-	for {
-		<-ticker.C
-		if !c.IsAlive() {
-			return
-		}
-		c.out <- protocol.PingMsg
+	if atomic.SwapInt32(&c.connected, channelClosed) == channelIdle {
+		c.data.Clear()
+		return
+	}
+
+	if c.server == nil {
+		task()
+		return
+	}
+
+	if c.enqueue(task) {
+		c.drain()
 	}
 }
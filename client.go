@@ -1,63 +1,329 @@
 package shadiaosocketio
 
 import (
+	"context"
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/engineio"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
 	"net"
+	"net/url"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	webSocketProtocol       = "ws://"
-	webSocketSecureProtocol = "wss://"
-	socketIOUrl             = "/socket.io/?transport=websocket"
+	webSocketProtocol       = "ws"
+	webSocketSecureProtocol = "wss"
 )
 
 type Client struct {
 	methods
-	Channel
+	*Channel
+
+	baseUrl string
+	opts    ClientOptions
+
+	connected     chan struct{}
+	connectedOnce sync.Once
+
+	// attempt is the channel of the current connection attempt,
+	// connectErr is set before its socket is closed
+	attempt     *Channel
+	connectErr  error
+	attemptLock sync.Mutex
 }
 
 func GetUrl(host string, port int, secure bool) string {
-	var prefix string
-
+	u := url.URL{
+		Scheme:   webSocketProtocol,
+		Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:     DefaultPath,
+		RawQuery: "transport=websocket",
+	}
 	if secure {
-		prefix = webSocketSecureProtocol
-	} else {
-		prefix = webSocketProtocol
+		u.Scheme = webSocketSecureProtocol
 	}
 
-	return prefix + net.JoinHostPort(host, strconv.Itoa(port)) + socketIOUrl
+	return u.String()
 }
 
+/*
+*
+Connect to the server, returns once the websocket is connected without waiting for the
+socket.io handshake, so OnConnection handlers may be set after Dial
+*/
 func Dial(url string, tr websocket.Transport) (*Client, error) {
-	c := &Client{}
-	c.initChannel()
+	c := NewClient(url, ClientOptions{Transport: tr})
+	if err := c.dial(context.Background(), url, c.opts); err != nil {
+		return nil, err
+	}
 
-	var err error
+	return c, nil
+}
 
-	if tr.Protocol == protocol.Protocol3 {
-		url = url + "&EIO=3"
-	} else if tr.Protocol == protocol.Protocol4 {
-		url = url + "&EIO=4"
-	} else {
-		url = url + "&EIO=4"
+/*
+*
+Same as Dial, url of the server is built from base url and options
+*/
+func DialWithOptions(baseUrl string, opts ClientOptions) (*Client, error) {
+	opts, err := opts.refresh(context.Background(), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	c.conn, err = tr.Connect(url)
+	u, err := opts.Url(baseUrl)
 	if err != nil {
 		return nil, err
 	}
-	if err != nil {
+
+	c := NewClient(baseUrl, opts)
+	if err := c.dial(context.Background(), u, opts); err != nil {
 		return nil, err
 	}
 
-	go inLoop(&c.Channel, &c.methods)
-	go outLoop(&c.Channel, &c.methods)
+	return c, nil
+}
+
+/*
+*
+Same as NewClient followed by Connect, handlers set after DialContext may miss
+events sent by the server on connection
+*/
+func DialContext(ctx context.Context, baseUrl string, opts ClientOptions) (*Client, error) {
+	c := NewClient(baseUrl, opts)
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
 
+/*
+*
+Create client of the server with base url like "https://example.com:2233", it's not connected
+until Connect, so handlers may be set before any event is received
+*/
+func NewClient(baseUrl string, opts ClientOptions) *Client {
+	c := &Client{
+		baseUrl:   baseUrl,
+		opts:      opts,
+		connected: make(chan struct{}),
+	}
+	c.onConnection = func(ch *Channel) {
+		if !c.isAttempt(ch) {
+			return
+		}
+		c.connectedOnce.Do(func() {
+			close(c.connected)
+		})
+	}
+	c.onConnectError = func(ch *Channel, err *ConnectError) {
+		c.attemptLock.Lock()
+		defer c.attemptLock.Unlock()
+
+		if c.attempt == ch {
+			c.connectErr = err
+		}
+	}
+
+	return c
+}
+
+/*
+*
+Connect to the server and wait for the namespace connection, ConnectError is returned
+if the server refused it. Failed attempts are repeated up to Attempts of options,
+HandshakeTimeout limits every attempt and ctx all of them. Every attempt has a new channel,
+handlers of the client are kept
+*/
+func (c *Client) Connect(ctx context.Context) error {
+	attempts := c.opts.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 && c.opts.RetryDelay > 0 {
+			timer := time.NewTimer(c.opts.RetryDelay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return lastErr
+			}
+		}
+
+		err := c.connectAttempt(ctx, lastErr)
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return lastErr
+}
+
+func (c *Client) connectAttempt(ctx context.Context, lastErr error) error {
+	opts, err := c.opts.refresh(ctx, lastErr)
+	if err != nil {
+		return err
+	}
+
+	u, err := opts.Url(c.baseUrl)
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := c.dial(ctx, u, opts); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrorHandshakeTimeout
+		}
+		return err
+	}
+
+	ch := c.Channel
+	select {
+	case <-c.connected:
+		return nil
+	case <-ch.Context().Done():
+		c.attemptLock.Lock()
+		defer c.attemptLock.Unlock()
+
+		if c.connectErr != nil {
+			return c.connectErr
+		}
+		return ErrorConnectClosed
+	case <-ctx.Done():
+		ch.socket.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrorHandshakeTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+/*
+*
+Bind a new channel to engine.io client and connect it to url
+*/
+func (c *Client) dial(ctx context.Context, url string, opts ClientOptions) error {
+	ec := engineio.NewClient(opts.getTransport())
+	if opts.Protocol != 0 {
+		ec.SetProtocol(opts.Protocol)
+	}
+
+	ch := &Channel{}
+	ch.handshake = newClientHandshake(url, ec.Protocol())
+	ch.handshake.Headers = websocket.MergeHeader(ch.handshake.Headers, opts.Header)
+	ch.handshake.Auth = opts.Auth
+	ch.initChannel(&ec.Socket, &c.methods)
+	ec.OnOpen(func(s *engineio.Socket) {
+		c.onOpen(ch, opts.Auth)
+	})
+
+	c.attemptLock.Lock()
+	c.attempt = ch
+	c.connectErr = nil
+	c.attemptLock.Unlock()
+	c.Channel = ch
+
+	return ec.DialContext(ctx, url, opts.Header)
+}
+
+func (c *Client) isAttempt(ch *Channel) bool {
+	c.attemptLock.Lock()
+	defer c.attemptLock.Unlock()
+
+	return c.attempt == ch
+}
+
+/*
+*
+Closed once the client is connected to the namespace
+*/
+func (c *Client) Connected() <-chan struct{} {
+	return c.connected
+}
+
+/*
+*
+Closed once the client is disconnected
+*/
+func (c *Client) Done() <-chan struct{} {
+	return c.Context().Done()
+}
+
+/*
+*
+engine.io open packet received
+*/
+func (c *Client) onOpen(ch *Channel, auth map[string]interface{}) {
+	ch.sid = ch.socket.Id()
+	ch.handshake.Time = time.Now()
+	ch.handshake.Issued = ch.handshake.Time.UnixMilli()
+	ch.handshake.Address = ch.socket.RemoteAddr().String()
+
+	if ch.socket.Protocol() == protocol.Protocol3 {
+		c.connectClient(ch, "")
+		return
+	}
+
+	// in protocol v4 & binary msg Connection to a namespace
+	if ch.BinaryMessage() {
+		var data interface{} = &struct{}{}
+		if auth != nil {
+			data = auth
+		}
+		ch.sendPacket(&protocol.MsgPack{
+			Type: protocol.CONNECT,
+			Nsp:  protocol.DefaultNsp,
+			Data: data,
+		})
+		// in protocol v4 & text msg Connection to a namespace
+	} else if auth != nil {
+		data, err := utils.Json.Marshal(auth)
+		if err != nil {
+			ch.socket.Send(protocol.OpenMsg)
+			return
+		}
+		ch.socket.Send(protocol.OpenMsg + string(data))
+	} else {
+		ch.socket.Send(protocol.OpenMsg)
+	}
+}
+
+/*
+*
+Client channel connected to the namespace, sid of CONNECT packet is set and OnConnection
+is called once. In protocol v3 the channel is connected on open, CONNECT of the server is ignored
+*/
+func (m *methods) connectClient(c *Channel, sid string) {
+	if !atomic.CompareAndSwapInt32(&c.connected, channelIdle, channelConnected) {
+		return
+	}
+
+	if sid != "" {
+		c.sid = sid
+	}
+	m.callLoopEvent(c, OnConnection)
+}
+
 func (c *Client) Close() {
-	closeChannel(&c.Channel, &c.methods)
+	c.socket.Close()
 }
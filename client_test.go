@@ -0,0 +1,107 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"github.com/gobwas/ws"
+)
+
+func TestClientReceivesConnectionEvents(t *testing.T) {
+	s := NewServer(websocket.GetDefaultWebsocketTransport())
+	s.On(OnConnection, func(c *Channel) {
+		c.Emit("welcome", c.Id())
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	const clients = 50
+	welcomes := make(chan string, clients)
+	for i := 0; i < clients; i++ {
+		c := NewClient(ts.URL, ClientOptions{})
+		if err := c.On("welcome", func(h *Channel, sid string) {
+			welcomes <- sid
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := c.Connect(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	for i := 0; i < clients; i++ {
+		select {
+		case <-welcomes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d clients got welcome", i, clients)
+		}
+	}
+}
+
+func TestClientConnectAttempts(t *testing.T) {
+	s := NewServer(websocket.GetDefaultWebsocketTransport())
+	s.On(OnConnection, func(c *Channel) {
+		c.Emit("welcome")
+	})
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first connection is closed before the namespace connection
+		if atomic.AddInt32(&requests, 1) == 1 {
+			if conn, _, _, err := ws.UpgradeHTTP(r, w); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	var connections, disconnections int32
+	welcome := make(chan struct{}, 1)
+	c := NewClient(ts.URL, ClientOptions{Attempts: 2})
+	c.On(OnConnection, func(h *Channel) {
+		atomic.AddInt32(&connections, 1)
+	})
+	c.On(OnDisconnection, func(h *Channel) {
+		atomic.AddInt32(&disconnections, 1)
+	})
+	c.On("welcome", func(h *Channel) {
+		welcome <- struct{}{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case <-welcome:
+	case <-time.After(5 * time.Second):
+		t.Fatal("welcome isn't received after retry")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("%d connections, want 1", n)
+	}
+	if n := atomic.LoadInt32(&disconnections); n != 0 {
+		t.Errorf("%d disconnections of failed attempt", n)
+	}
+	if !c.IsAlive() {
+		t.Error("client isn't alive")
+	}
+}
@@ -0,0 +1,59 @@
+package shadiaosocketio
+
+import (
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+)
+
+var (
+	ErrorHandshakeTimeout = errors.New("handshake timeout")
+	ErrorConnectClosed    = errors.New("connection closed before connect")
+)
+
+/*
+*
+Error of CONNECT_ERROR packet sent by the server when the namespace connection is refused
+*/
+type ConnectError struct {
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *ConnectError) Error() string {
+	return e.Message
+}
+
+/*
+*
+Parse payload of CONNECT_ERROR packet, it's {"message":..,"data":..} in protocol v4 and a string in v3
+*/
+func parseConnectError(payload interface{}) *ConnectError {
+	data, err := ackValueJson(payload)
+	if err != nil || len(data) == 0 {
+		return &ConnectError{}
+	}
+
+	connectErr := &ConnectError{}
+	if data[0] == '"' {
+		_ = utils.Json.Unmarshal(data, &connectErr.Message)
+		return connectErr
+	}
+
+	_ = utils.Json.Unmarshal(data, connectErr)
+	return connectErr
+}
+
+/*
+*
+Server refused the connection, error is passed to OnConnectError handlers and the socket is closed
+*/
+func (m *methods) connectError(c *Channel, payload interface{}) {
+	connectErr := parseConnectError(payload)
+
+	if m.onConnectError != nil {
+		m.onConnectError(c, connectErr)
+	}
+	m.callLoopEvent(c, OnConnectError, connectErr)
+
+	c.socket.CloseWithReason(connectErr)
+}
@@ -0,0 +1,156 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func newTestServer(t *testing.T, s *Server) string {
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	return "ws://" + strings.TrimPrefix(ts.URL, "http://") + DefaultPath + "?EIO=4&transport=websocket"
+}
+
+func dialRaw(t *testing.T, url string) net.Conn {
+	conn, br, _, err := ws.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br != nil {
+		ws.PutReader(br)
+	}
+
+	return conn
+}
+
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return true
+}
+
+func TestConnectThenClose(t *testing.T) {
+	s := NewServer(websocket.GetDefaultWebsocketTransport())
+
+	var lock sync.Mutex
+	connected := make(map[*Channel]bool)
+	connections, disconnections, early := 0, 0, 0
+	s.On(OnConnection, func(c *Channel) {
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		connected[c] = true
+		connections++
+	})
+	s.On(OnDisconnection, func(c *Channel) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !connected[c] {
+			early++
+		}
+		disconnections++
+	})
+
+	url := newTestServer(t, s)
+	const cycles = 300
+	for i := 0; i < cycles; i++ {
+		conn := dialRaw(t, url)
+		if err := wsutil.WriteClientText(conn, []byte("40")); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	if !waitFor(t, func() bool { return s.AmountOfSids() == 0 }) {
+		t.Errorf("AmountOfSids() = %d, want 0", s.AmountOfSids())
+	}
+	if !waitFor(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return disconnections == connections
+	}) {
+		t.Errorf("%d disconnections of %d connections", disconnections, connections)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if early > 0 {
+		t.Errorf("%d disconnections before connection", early)
+	}
+}
+
+func TestCloseBeforeConnect(t *testing.T) {
+	s := NewServer(websocket.GetDefaultWebsocketTransport())
+
+	var lock sync.Mutex
+	disconnections := 0
+	s.On(OnDisconnection, func(c *Channel) {
+		lock.Lock()
+		defer lock.Unlock()
+		disconnections++
+	})
+
+	url := newTestServer(t, s)
+	for i := 0; i < 10; i++ {
+		dialRaw(t, url).Close()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if disconnections != 0 {
+		t.Errorf("%d disconnections of never connected channels", disconnections)
+	}
+}
+
+func TestDisconnectionPanic(t *testing.T) {
+	s := NewServer(websocket.GetDefaultWebsocketTransport())
+
+	errs := make(chan *HandlerError, 2)
+	s.On(OnError, func(c *Channel, err *HandlerError) {
+		errs <- err
+	})
+	s.On(OnDisconnection, func(c *Channel, reason string) {
+		panic("unreachable")
+	})
+
+	url := newTestServer(t, s)
+	conn := dialRaw(t, url)
+	if err := wsutil.WriteClientText(conn, []byte("40")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case err := <-errs:
+		if err.Event != OnDisconnection || err.Panic == nil {
+			t.Errorf("unexpected error %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic of disconnection handler isn't reported")
+	}
+
+	if !waitFor(t, func() bool { return s.AmountOfSids() == 0 }) {
+		t.Errorf("AmountOfSids() = %d, want 0", s.AmountOfSids())
+	}
+}
@@ -0,0 +1,68 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"time"
+)
+
+/*
+*
+Event middleware, called before the handler of every event. Returned context
+is passed to the next middleware and to the handler, error drops the event
+and answers its ack with the error
+*/
+type Middleware func(ctx context.Context, c *Channel, event string) (context.Context, error)
+
+/*
+*
+Context of the channel, cancelled when the channel is closed
+*/
+func (c *Channel) Context() context.Context {
+	return c.ctx
+}
+
+/*
+*
+Add middleware called before handlers of events, should be called before serving or dialing
+*/
+func (m *methods) Use(mw Middleware) {
+	m.middlewares = append(m.middlewares, mw)
+}
+
+/*
+*
+Set deadline of context passed to handlers of events, 0 means no deadline
+*/
+func (m *methods) SetEventTimeout(timeout time.Duration) {
+	m.eventTimeout = timeout
+}
+
+/*
+*
+Derive context of the event from the channel context and run middlewares,
+cancel must be called when the handler returns
+*/
+func (m *methods) eventContext(c *Channel, event string) (context.Context, context.CancelFunc, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if m.eventTimeout > 0 {
+		ctx, cancel = context.WithTimeout(c.Context(), m.eventTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.Context())
+	}
+
+	for _, mw := range m.middlewares {
+		next, err := mw(ctx, c, event)
+		if err != nil {
+			utils.Debug("[middleware] event dropped:", event, err)
+			cancel()
+			return nil, nil, err
+		}
+		if next != nil {
+			ctx = next
+		}
+	}
+
+	return ctx, cancel, nil
+}
@@ -0,0 +1,118 @@
+package shadiaosocketio
+
+import (
+	"sync"
+)
+
+/*
+*
+Concurrency safe store of the channel, lives as long as the channel
+and is cleared on disconnection
+*/
+type Data struct {
+	lock   sync.RWMutex
+	values map[string]interface{}
+	value  interface{}
+}
+
+/*
+*
+Store of the channel
+*/
+func (c *Channel) Data() *Data {
+	return &c.data
+}
+
+func (d *Data) Set(key string, v interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.values == nil {
+		d.values = make(map[string]interface{})
+	}
+	d.values[key] = v
+}
+
+func (d *Data) Get(key string) (interface{}, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	v, ok := d.values[key]
+	return v, ok
+}
+
+func (d *Data) Delete(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.values, key)
+}
+
+/*
+*
+Set user defined value of the channel, e.g. session struct
+*/
+func (d *Data) SetValue(v interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.value = v
+}
+
+func (d *Data) Value() interface{} {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.value
+}
+
+/*
+*
+Remove all keys and the user defined value
+*/
+func (d *Data) Clear() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.values = nil
+	d.value = nil
+}
+
+/*
+*
+Typed key of channel store:
+
+	var userKey = NewKey[*User]("user")
+	userKey.Set(c.Data(), user)
+	user, ok := userKey.Get(c.Data())
+*/
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) Set(d *Data, v T) {
+	d.Set(k.name, v)
+}
+
+/*
+*
+Get value of the key, ok is false if there is no value or it has another type
+*/
+func (k Key[T]) Get(d *Data) (T, bool) {
+	v, ok := d.Get(k.name)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+
+	t, ok := v.(T)
+	return t, ok
+}
+
+func (k Key[T]) Delete(d *Data) {
+	d.Delete(k.name)
+}
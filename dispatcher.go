@@ -0,0 +1,314 @@
+package shadiaosocketio
+
+import (
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"sync"
+)
+
+/*
+*
+What to do with incoming packet when dispatcher limits are hit
+*/
+type OverflowPolicy int
+
+const (
+	// block reading of the connection until there is room
+	OverflowBlock OverflowPolicy = iota
+	// drop the packet, acks of dropped events are answered with ErrorDispatcherOverflow
+	OverflowDrop
+	// disconnect the channel
+	OverflowDisconnect
+)
+
+var (
+	ErrorDispatcherOverflow = errors.New("dispatcher overflow")
+	ErrorDispatcherWorkers  = errors.New("dispatcher requires at least one worker")
+)
+
+/*
+*
+Limits of incoming packets processing, CONNECT and DISCONNECT packets are never limited
+*/
+type DispatcherConfig struct {
+	// Workers is the max amount of handlers running concurrently
+	Workers int
+	// QueueSize is the amount of packets waiting for a free worker
+	QueueSize int
+	// MaxInFlight is the max amount of packets queued or running per channel, 0 means no limit
+	MaxInFlight int
+
+	Policy OverflowPolicy
+}
+
+/*
+*
+Shared worker pool processing incoming packets
+*/
+type dispatcher struct {
+	cfg   DispatcherConfig
+	tasks chan func()
+
+	stopLock sync.RWMutex
+	stopped  bool
+}
+
+func newDispatcher(cfg DispatcherConfig) *dispatcher {
+	if cfg.QueueSize < 0 {
+		cfg.QueueSize = 0
+	}
+
+	d := &dispatcher{
+		cfg:   cfg,
+		tasks: make(chan func(), cfg.QueueSize),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+func (d *dispatcher) work() {
+	for task := range d.tasks {
+		task()
+	}
+}
+
+/*
+*
+Queue task, returns false if there is no room and policy is not blocking
+*/
+func (d *dispatcher) submit(task func()) bool {
+	d.stopLock.RLock()
+	defer d.stopLock.RUnlock()
+
+	if d.stopped {
+		// dispatcher was replaced while the packet was being dispatched
+		go task()
+		return true
+	}
+
+	if d.cfg.Policy == OverflowBlock {
+		d.tasks <- task
+		return true
+	}
+
+	select {
+	case d.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+*
+Stop workers once queued tasks are done
+*/
+func (d *dispatcher) stop() {
+	d.stopLock.Lock()
+	defer d.stopLock.Unlock()
+
+	if d.stopped {
+		return
+	}
+
+	d.stopped = true
+	close(d.tasks)
+}
+
+/*
+*
+Set limits of incoming packets processing, should be called before serving or dialing.
+Without dispatcher every packet is processed in it's own goroutine.
+Workers of the previous dispatcher exit once its queue is done
+*/
+func (m *methods) SetDispatcher(cfg DispatcherConfig) error {
+	if cfg.Workers <= 0 {
+		return ErrorDispatcherWorkers
+	}
+
+	prev := m.dispatcher
+	m.dispatcher = newDispatcher(cfg)
+	if prev != nil {
+		prev.stop()
+	}
+
+	return nil
+}
+
+/*
+*
+Process events of every channel sequentially in arrival order, different channels
+are still processed concurrently. Handlers registered with OnParallel are not ordered
+*/
+func (m *methods) SetOrdered(ordered bool) {
+	m.ordered = ordered
+}
+
+/*
+*
+Checks if packet of given event should wait for previous packets of the channel
+*/
+func (m *methods) isOrdered(event string) bool {
+	if !m.ordered {
+		return false
+	}
+
+	if f, ok := m.findMethod(event); ok && f.Parallel {
+		return false
+	}
+
+	return true
+}
+
+/*
+*
+Process packet of the channel according to dispatcher limits,
+ordered packets are put to the serial queue of the channel.
+AckId is id of the event ack, -1 if the packet has none
+*/
+func (m *methods) dispatch(c *Channel, ordered bool, ackId int, f func()) {
+	d := m.dispatcher
+	if d == nil {
+		if !ordered {
+			go f()
+			return
+		}
+		if c.enqueue(f) {
+			go c.drain()
+		}
+		return
+	}
+
+	if !c.acquireInFlight(d.cfg) {
+		m.overflow(c, d.cfg, ackId)
+		return
+	}
+
+	task := func() {
+		defer c.releaseInFlight()
+		f()
+	}
+	if ordered {
+		if !c.enqueue(task) {
+			// queue is being drained already
+			return
+		}
+		task = c.drain
+	}
+
+	if !d.submit(task) {
+		if ordered {
+			c.resetQueue()
+		}
+		c.releaseInFlight()
+		m.overflow(c, d.cfg, ackId)
+	}
+}
+
+func (m *methods) overflow(c *Channel, cfg DispatcherConfig, ackId int) {
+	switch cfg.Policy {
+	case OverflowDrop:
+		utils.Debug("[dispatch] packet dropped, channel:", c.Id())
+		if ackId >= 0 {
+			m.sendAckError(c, ackId, ErrorDispatcherOverflow)
+		}
+	case OverflowDisconnect:
+		closeErr := &websocket.CloseError{}
+		closeErr.Code = websocket.DispatcherOverflowCode
+		closeErr.Text = ErrorDispatcherOverflow.Error()
+
+		c.socket.CloseWithReason(closeErr)
+	}
+}
+
+/*
+*
+Take a slot of in-flight packets of the channel
+*/
+func (c *Channel) acquireInFlight(cfg DispatcherConfig) bool {
+	if cfg.MaxInFlight <= 0 {
+		return true
+	}
+
+	c.inFlightOnce.Do(func() {
+		c.inFlight = make(chan struct{}, cfg.MaxInFlight)
+	})
+
+	if cfg.Policy == OverflowBlock {
+		c.inFlight <- struct{}{}
+		return true
+	}
+
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Channel) releaseInFlight() {
+	if c.inFlight == nil {
+		return
+	}
+
+	<-c.inFlight
+}
+
+/*
+*
+Put task to serial queue of the channel, returns true if the queue
+was idle and should be drained
+*/
+func (c *Channel) enqueue(task func()) bool {
+	c.serialLock.Lock()
+	defer c.serialLock.Unlock()
+
+	c.serial = append(c.serial, task)
+	if c.serialRunning {
+		return false
+	}
+
+	c.serialRunning = true
+	return true
+}
+
+/*
+*
+Run queued tasks one by one until the queue is empty
+*/
+func (c *Channel) drain() {
+	for {
+		c.serialLock.Lock()
+		if len(c.serial) == 0 {
+			c.serialRunning = false
+			c.serialLock.Unlock()
+			return
+		}
+
+		task := c.serial[0]
+		c.serial[0] = nil
+		c.serial = c.serial[1:]
+		c.serialLock.Unlock()
+
+		task()
+	}
+}
+
+/*
+*
+Drop queue which failed to be drained. Packets are dispatched from the read loop only,
+so the queue holds the single task which started it
+*/
+func (c *Channel) resetQueue() {
+	c.serialLock.Lock()
+	defer c.serialLock.Unlock()
+
+	c.serial = nil
+	c.serialRunning = false
+}
@@ -0,0 +1,100 @@
+package engineio
+
+import (
+	"context"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	transportWebsocket = "websocket"
+)
+
+/*
+*
+engine.io client, handlers should be set before Dial
+*/
+type Client struct {
+	Socket
+}
+
+/*
+*
+Set open handler, called when the open packet from server is received
+*/
+func (c *Client) OnOpen(f func(s *Socket)) {
+	c.onOpen = f
+}
+
+/*
+*
+Set engine.io protocol of the client, protocol of the transport is used by default
+*/
+func (c *Client) SetProtocol(protocolVersion int) {
+	c.protocol = protocolVersion
+}
+
+/*
+*
+Connect to engine.io server and start the socket
+*/
+func (c *Client) Dial(url string) error {
+	return c.DialContext(context.Background(), url, nil)
+}
+
+/*
+*
+Connect to engine.io server with additional request header and start the socket,
+ctx is used by transports implementing websocket.ContextTransport
+*/
+func (c *Client) DialContext(ctx context.Context, rawUrl string, header http.Header) error {
+	u, err := c.Url(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	var conn websocket.Conn
+	if tr, ok := c.tr.(websocket.ContextTransport); ok {
+		conn, err = tr.ConnectContext(ctx, u, header)
+	} else {
+		conn, err = c.tr.Connect(u)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.init(c.tr, conn)
+	c.Start()
+
+	return nil
+}
+
+/*
+*
+Get url with engine.io query params of the client
+*/
+func (c *Client) Url(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	if query.Get("transport") == "" {
+		query.Set("transport", transportWebsocket)
+	}
+	query.Set("EIO", strconv.Itoa(c.Protocol()))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func NewClient(tr websocket.Transport) *Client {
+	c := &Client{}
+	c.tr = tr
+	c.client = true
+
+	return c
+}
@@ -0,0 +1,47 @@
+package engineio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const (
+	maxRecordReadBytes  = 1024 * 1024 * 1024
+	maxRecordWriteBytes = 1024 * 1024 * 1024
+
+	// 15 random bytes are 20 chars of base64
+	idBytes = 15
+	// custom generator returning live ids is called again this amount of times
+	maxIdAttempts = 8
+)
+
+var (
+	ErrorSocketOverflood = errors.New("socket overflood")
+	ErrorSocketClosed    = errors.New("socket closed")
+	ErrorPingTimeout     = errors.New("ping timeout")
+)
+
+/*
+*
+engine.io header to send or receive
+*/
+type Header struct {
+	Sid          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int      `json:"pingInterval"`
+	PingTimeout  int      `json:"pingTimeout"`
+}
+
+/*
+*
+Generate new random id for engine.io connection
+*/
+func generateNewId() string {
+	buf := make([]byte, idBytes)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,96 @@
+package engineio
+
+import (
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	heartbeatBatch = 256
+)
+
+var (
+	heartbeats     = make(map[time.Duration]*heartbeat)
+	heartbeatsLock sync.Mutex
+)
+
+/*
+*
+Shared ping scheduler of polled sockets, one goroutine per ping interval
+instead of one per socket. Sockets which haven't sent anything
+for pingInterval + pingTimeout are closed
+*/
+type heartbeat struct {
+	interval time.Duration
+
+	sockets     map[*Socket]struct{}
+	socketsLock sync.Mutex
+}
+
+func scheduleHeartbeat(s *Socket) {
+	interval, _ := s.tr.PingParams()
+
+	heartbeatsLock.Lock()
+	h, ok := heartbeats[interval]
+	if !ok {
+		h = &heartbeat{
+			interval: interval,
+			sockets:  make(map[*Socket]struct{}),
+		}
+		heartbeats[interval] = h
+		go h.run()
+	}
+	heartbeatsLock.Unlock()
+
+	h.socketsLock.Lock()
+	h.sockets[s] = struct{}{}
+	h.socketsLock.Unlock()
+}
+
+func (h *heartbeat) run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.tick()
+	}
+}
+
+func (h *heartbeat) tick() {
+	h.socketsLock.Lock()
+	sockets := make([]*Socket, 0, len(h.sockets))
+	for s := range h.sockets {
+		if !s.IsAlive() {
+			delete(h.sockets, s)
+			continue
+		}
+		sockets = append(sockets, s)
+	}
+	h.socketsLock.Unlock()
+
+	// pings are written directly, a slow peer must not delay the others
+	for len(sockets) > 0 {
+		n := heartbeatBatch
+		if n > len(sockets) {
+			n = len(sockets)
+		}
+		go h.ping(sockets[:n])
+		sockets = sockets[n:]
+	}
+}
+
+func (h *heartbeat) ping(sockets []*Socket) {
+	now := time.Now().UnixNano()
+	for _, s := range sockets {
+		_, timeout := s.tr.PingParams()
+		if now-atomic.LoadInt64(&s.lastRead) > int64(h.interval+timeout) {
+			s.close(ErrorPingTimeout)
+			continue
+		}
+
+		if s.pinger() {
+			s.push(protocol.PingMsg)
+		}
+	}
+}
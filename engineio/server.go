@@ -0,0 +1,164 @@
+package engineio
+
+import (
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+*
+engine.io server instance
+*/
+type Server struct {
+	tr websocket.Transport
+
+	idGenerator func(r *http.Request) string
+	sids        map[string]struct{}
+	sidsLock    sync.Mutex
+
+	onConnection func(s *Socket)
+}
+
+/*
+*
+Set connection handler, it's called before the socket starts,
+so OnMessage and OnClose handlers of the socket can be set here
+*/
+func (srv *Server) OnConnection(f func(s *Socket)) {
+	srv.onConnection = f
+}
+
+/*
+*
+Set generator of socket ids, e.g. to embed node id for sticky routing. Ids are unique among
+live sockets, the generator is called again for taken ids and a random suffix is added at last
+*/
+func (srv *Server) SetIdGenerator(f func(r *http.Request) string) {
+	srv.idGenerator = f
+}
+
+/*
+*
+Generate id which is not used by live sockets and reserve it until the socket is closed
+*/
+func (srv *Server) reserveId(r *http.Request) string {
+	id := ""
+	for i := 0; i < maxIdAttempts; i++ {
+		if srv.idGenerator != nil {
+			id = srv.idGenerator(r)
+		} else {
+			id = generateNewId()
+		}
+
+		if id != "" && srv.tryReserve(id) {
+			return id
+		}
+	}
+
+	base := id
+	for {
+		id = generateNewId()
+		if base != "" {
+			id = base + "-" + id
+		}
+		if srv.tryReserve(id) {
+			return id
+		}
+	}
+}
+
+func (srv *Server) tryReserve(id string) bool {
+	srv.sidsLock.Lock()
+	defer srv.sidsLock.Unlock()
+
+	if _, ok := srv.sids[id]; ok {
+		return false
+	}
+
+	srv.sids[id] = struct{}{}
+	return true
+}
+
+func (srv *Server) release(id string) {
+	srv.sidsLock.Lock()
+	defer srv.sidsLock.Unlock()
+
+	delete(srv.sids, id)
+}
+
+/*
+*
+Create socket for given connection and queue the open packet,
+the socket should be started with Start
+*/
+func (srv *Server) NewSocket(conn websocket.Conn, r *http.Request) *Socket {
+	interval, timeout := srv.tr.PingParams()
+
+	sid := srv.reserveId(r)
+
+	s := newSocket(srv.tr, conn)
+	s.request = r
+	s.release = func() {
+		srv.release(sid)
+	}
+	s.header = Header{
+		Sid:          sid,
+		Upgrades:     []string{},
+		PingInterval: int(interval / time.Millisecond),
+		PingTimeout:  int(timeout / time.Millisecond),
+	}
+
+	jsonHdr, err := utils.Json.Marshal(&s.header)
+	if err != nil {
+		panic(err)
+	}
+
+	// GET /socket.io/?EIO=4&transport=polling&t=N8hyd6w
+	// < HTTP/1.1 200 OK
+	// < Content-Type: text/plain; charset=UTF-8
+	// 0{"sid":"lv_VI97HAXpY6yYWAAAC","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000,"maxPayload":1000000}
+	s.push(protocol.OpenMsg + string(jsonHdr))
+
+	return s
+}
+
+/*
+*
+implements ServeHTTP function from http.Handler
+*/
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ct, ok := srv.tr.(websocket.CorsTransport); ok && ct.GetCors().Handle(w, r) {
+		return
+	}
+
+	conn, err := srv.tr.HandleConnection(w, r)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	s := srv.NewSocket(conn, r)
+	if srv.onConnection != nil {
+		srv.onConnection(s)
+	}
+	s.Start()
+
+	srv.tr.Serve(w, r)
+}
+
+func (srv *Server) UpdateTransport(tr websocket.Transport) {
+	srv.tr = tr
+}
+
+func NewServer(tr websocket.Transport) *Server {
+	srv := Server{}
+	srv.tr = tr
+	srv.sids = make(map[string]struct{})
+
+	return &srv
+}
@@ -0,0 +1,677 @@
+package engineio
+
+import (
+	"context"
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+*
+engine.io message handler, binary is set for binary frames.
+It's called from the read loop, long running work should be done in another goroutine
+*/
+type MessageHandler func(s *Socket, data []byte, binary bool)
+
+/*
+*
+engine.io close handler, reason is nil when the socket was closed normally
+*/
+type CloseHandler func(s *Socket, reason error)
+
+/*
+*
+Outgoing queue watermark handler, high is set when the queue has grown to the
+high watermark, and unset when it has been drained to the low one
+*/
+type WatermarkHandler func(s *Socket, high bool)
+
+/*
+*
+engine.io socket, transport agnostic
+
+takes care of open packet, ping/pong and close, message packets are
+passed to OnMessage handler. Handlers must be set before the socket starts
+*/
+type Socket struct {
+	tr   websocket.Transport
+	conn websocket.Conn
+
+	writeBytes int
+	readBytes  int
+
+	out       chan interface{}
+	queueSize int
+	done      chan struct{}
+	header    Header
+
+	highWatermark int
+	lowWatermark  int
+	aboveHigh     int32
+	onWatermark   WatermarkHandler
+
+	// polled sockets are driven by the transport, packets are written directly
+	polled    bool
+	writeLock sync.Mutex
+	lastRead  int64
+
+	alive     bool
+	aliveLock sync.Mutex
+
+	client   bool
+	protocol int
+	request  *http.Request
+	// release frees the sid on the server
+	release func()
+
+	onOpen    func(s *Socket)
+	onMessage MessageHandler
+	onClose   CloseHandler
+}
+
+func newSocket(tr websocket.Transport, conn websocket.Conn) *Socket {
+	s := &Socket{}
+	s.init(tr, conn)
+
+	return s
+}
+
+func (s *Socket) init(tr websocket.Transport, conn websocket.Conn) {
+	s.tr = tr
+	s.conn = conn
+	s.done = make(chan struct{})
+	if _, ok := conn.(websocket.PollConn); !ok {
+		s.initQueue()
+	}
+	s.setAliveValue(true)
+}
+
+func (s *Socket) initQueue() {
+	s.queueSize = s.tr.GetQueueSize()
+	if s.queueSize <= 0 {
+		s.queueSize = websocket.WsDefaultQueueSize
+	}
+	s.out = make(chan interface{}, s.queueSize)
+}
+
+/*
+*
+Get engine.io sid
+*/
+func (s *Socket) Id() string {
+	return s.header.Sid
+}
+
+/*
+*
+Get engine.io header sent or received with the open packet
+*/
+func (s *Socket) Header() Header {
+	return s.header
+}
+
+/*
+*
+Get underlying transport connection
+*/
+func (s *Socket) Conn() websocket.Conn {
+	return s.conn
+}
+
+/*
+*
+Get transport the connection was created with
+*/
+func (s *Socket) Transport() websocket.Transport {
+	return s.tr
+}
+
+/*
+*
+Get request of this connection, nil on client side
+*/
+func (s *Socket) Request() *http.Request {
+	return s.request
+}
+
+/*
+*
+Get engine.io protocol of the socket, protocol of the transport unless set by the client
+*/
+func (s *Socket) Protocol() int {
+	if s.protocol != 0 {
+		return s.protocol
+	}
+	return s.tr.GetProtocol()
+}
+
+/*
+*
+Get amount of bytes read since the last call
+*/
+func (s *Socket) ReadBytes() int {
+	v := s.readBytes
+	s.readBytes = 0
+	return v
+}
+
+/*
+*
+Get amount of bytes written since the last call
+*/
+func (s *Socket) WriteBytes() int {
+	v := s.writeBytes
+	s.writeBytes = 0
+	return v
+}
+
+func (s *Socket) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Socket) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Socket) OnMessage(f MessageHandler) {
+	s.onMessage = f
+}
+
+func (s *Socket) OnClose(f CloseHandler) {
+	s.onClose = f
+}
+
+/*
+*
+Checks that Socket is still alive
+*/
+func (s *Socket) IsAlive() bool {
+	s.aliveLock.Lock()
+	isAlive := s.alive
+	s.aliveLock.Unlock()
+
+	return isAlive
+}
+
+func (s *Socket) setAliveValue(value bool) {
+	s.aliveLock.Lock()
+	s.alive = value
+	s.aliveLock.Unlock()
+}
+
+/*
+*
+Send text message packet, returns ErrorSocketOverflood if outgoing queue is full
+*/
+func (s *Socket) Send(data string) error {
+	return s.push(protocol.CommonMsg + data)
+}
+
+/*
+*
+Send binary message packet, returns ErrorSocketOverflood if outgoing queue is full
+*/
+func (s *Socket) SendBinary(data []byte) error {
+	return s.push(s.binaryFrame(data))
+}
+
+/*
+*
+Send text message packet, waits for room in outgoing queue until ctx is done
+*/
+func (s *Socket) SendContext(ctx context.Context, data string) error {
+	return s.pushContext(ctx, protocol.CommonMsg+data)
+}
+
+/*
+*
+Send binary message packet, waits for room in outgoing queue until ctx is done
+*/
+func (s *Socket) SendBinaryContext(ctx context.Context, data []byte) error {
+	return s.pushContext(ctx, s.binaryFrame(data))
+}
+
+func (s *Socket) binaryFrame(data []byte) []byte {
+	if s.Protocol() == protocol.Protocol3 {
+		// in protocol v3, binary frames are prefixed with the packet type
+		frame := make([]byte, 0, 1+len(data))
+		frame = append(frame, protocol.CommonMsg[0]-'0')
+		data = append(frame, data...)
+	}
+
+	return data
+}
+
+/*
+*
+Get amount of packets waiting in outgoing queue
+*/
+func (s *Socket) Queued() int {
+	return len(s.out)
+}
+
+/*
+*
+Set outgoing queue watermarks, f is called when the queue grows to high
+and when it's drained to low afterwards. Polled sockets have no queue
+*/
+func (s *Socket) SetWatermarks(high, low int, f WatermarkHandler) {
+	s.highWatermark = high
+	s.lowWatermark = low
+	s.onWatermark = f
+}
+
+func (s *Socket) push(packet interface{}) error {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("engine.io send panic: ", r)
+		}
+	}()
+
+	if !s.IsAlive() {
+		return nil
+	}
+
+	if s.out == nil {
+		return s.writeDirect(packet)
+	}
+
+	select {
+	case s.out <- packet:
+	default:
+		return ErrorSocketOverflood
+	}
+
+	s.checkHighWatermark()
+	return nil
+}
+
+func (s *Socket) pushContext(ctx context.Context, packet interface{}) error {
+	if !s.IsAlive() {
+		return nil
+	}
+
+	if s.out == nil {
+		return s.writeDirect(packet)
+	}
+
+	select {
+	case s.out <- packet:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.done:
+		return nil
+	}
+
+	s.checkHighWatermark()
+	return nil
+}
+
+func (s *Socket) writeDirect(packet interface{}) error {
+	s.writeLock.Lock()
+	err := s.write(packet)
+	s.writeLock.Unlock()
+
+	if err != nil {
+		closeErr := &websocket.CloseError{}
+		closeErr.Code = websocket.WriteBufferErrCode
+		closeErr.Text = err.Error()
+
+		s.close(closeErr)
+	}
+	return err
+}
+
+func (s *Socket) checkHighWatermark() {
+	if s.onWatermark == nil || s.highWatermark <= 0 || len(s.out) < s.highWatermark {
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&s.aboveHigh, 0, 1) {
+		s.onWatermark(s, true)
+	}
+}
+
+func (s *Socket) checkLowWatermark() {
+	if s.onWatermark == nil || atomic.LoadInt32(&s.aboveHigh) == 0 || len(s.out) > s.lowWatermark {
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&s.aboveHigh, 1, 0) {
+		s.onWatermark(s, false)
+	}
+}
+
+/*
+*
+Start reading and writing loops, or register the socket
+in the transport reactor if the connection supports it
+*/
+func (s *Socket) Start() {
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+
+	if pc, ok := s.conn.(websocket.PollConn); ok {
+		// frames may be handled by workers before Poll returns, failed Poll doesn't arm the socket
+		s.polled = true
+		err := pc.Poll(s.onFrame)
+		if err == nil {
+			scheduleHeartbeat(s)
+			return
+		}
+		s.polled = false
+
+		utils.Debug("[Start] poll is not available, fallback to loops:", err)
+		s.writeLock.Lock()
+		s.initQueue()
+		s.writeLock.Unlock()
+	}
+
+	go s.inLoop()
+	go s.outLoop()
+
+	if s.pinger() {
+		go s.schedulePing()
+	}
+}
+
+/*
+*
+in protocol v4, the server sends a ping, and the client answers with a pong
+in protocol v3, the client sends a ping, and the server answers with a pong
+*/
+func (s *Socket) pinger() bool {
+	if s.Protocol() == protocol.Protocol3 {
+		return s.client
+	}
+
+	return !s.client
+}
+
+/*
+*
+Close socket
+*/
+func (s *Socket) Close() {
+	s.close(nil)
+}
+
+/*
+*
+Close socket, reason is passed to OnClose handler
+*/
+func (s *Socket) CloseWithReason(reason error) {
+	s.close(reason)
+}
+
+func (s *Socket) close(reason error) error {
+	s.aliveLock.Lock()
+	if !s.alive {
+		//already closed
+		s.aliveLock.Unlock()
+		return nil
+	}
+	s.alive = false
+	s.aliveLock.Unlock()
+
+	s.conn.Close()
+	close(s.done)
+	if s.release != nil {
+		s.release()
+	}
+
+	if s.onClose != nil {
+		s.onClose(s, reason)
+	}
+
+	return nil
+}
+
+/*
+*
+Frame handler of polled socket, called by the transport worker pool
+*/
+func (s *Socket) onFrame(messageType int, data []byte, err error) {
+	if err != nil {
+		s.close(err)
+		return
+	}
+
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+	s.handleFrame(messageType, data)
+}
+
+// incoming messages loop, passes frames to handleFrame
+func (s *Socket) inLoop() error {
+	for {
+		msgType, data, err := s.conn.ReadFrame()
+		if err != nil {
+			return s.close(err)
+		}
+
+		if err := s.handleFrame(msgType, data); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+*
+Handle engine.io packet and pass messages to OnMessage handler,
+returns non-nil error if the socket was closed
+*/
+func (s *Socket) handleFrame(msgType int, data []byte) error {
+	if s.readBytes > maxRecordReadBytes {
+		s.readBytes = 0
+	}
+	s.readBytes += len(data)
+	if len(data) == 0 {
+		return nil
+	}
+
+	if msgType == websocket.BinaryMessage {
+		// in protocol v3, binary frames are prefixed with the packet type
+		if s.Protocol() == protocol.Protocol3 {
+			data = data[1:]
+		}
+		s.dispatch(data, true)
+		return nil
+	}
+
+	switch string(data[0]) {
+	case protocol.OpenMsg:
+		if err := utils.Json.Unmarshal(data[1:], &s.header); err != nil {
+			closeErr := &websocket.CloseError{}
+			closeErr.Code = websocket.ParseOpenMsgCode
+			closeErr.Text = err.Error()
+
+			s.close(closeErr)
+			return closeErr
+		}
+
+		if s.client && s.pinger() && !s.polled {
+			go s.schedulePing()
+		}
+		if s.onOpen != nil {
+			s.onOpen(s)
+		}
+	case protocol.CloseMsg:
+		s.close(nil)
+		return ErrorSocketClosed
+	case protocol.PingMsg:
+		// in protocol v4, the server sends a ping, and the client answers with a pong
+		s.push(protocol.PongMsg)
+	case protocol.PongMsg:
+	case protocol.UpgradeMsg:
+	case protocol.CommonMsg:
+		s.dispatch(data[1:], false)
+	}
+
+	return nil
+}
+
+/*
+*
+OnMessage handler is called from the read loop, or the transport worker pool
+for polled sockets, so it blocks reading until it returns
+*/
+func (s *Socket) dispatch(data []byte, binary bool) {
+	if s.onMessage == nil {
+		return
+	}
+
+	s.onMessage(s, data, binary)
+}
+
+func (s *Socket) outLoop() error {
+	size, latency := s.tr.WriteBatchParams()
+	_, batched := s.conn.(websocket.BatchConn)
+	if !batched || size <= 1 {
+		return s.outLoopSingle()
+	}
+
+	batch := make([]websocket.Frame, 0, size)
+	for {
+		select {
+		case msg := <-s.out:
+			batch = append(batch, frame(msg))
+		case <-s.done:
+			return nil
+		}
+
+		batch = s.collect(batch, size, latency)
+		s.checkLowWatermark()
+
+		err := s.writeBatch(batch)
+		for i := range batch {
+			batch[i].Data = nil
+		}
+		batch = batch[:0]
+
+		if err != nil {
+			closeErr := &websocket.CloseError{}
+			closeErr.Code = websocket.WriteBufferErrCode
+			closeErr.Text = err.Error()
+
+			s.close(closeErr)
+		}
+	}
+}
+
+func (s *Socket) outLoopSingle() error {
+	for {
+		var msg interface{}
+		select {
+		case msg = <-s.out:
+		case <-s.done:
+			return nil
+		}
+
+		s.checkLowWatermark()
+
+		err := s.write(msg)
+		if err != nil {
+			closeErr := &websocket.CloseError{}
+			closeErr.Code = websocket.WriteBufferErrCode
+			closeErr.Text = err.Error()
+
+			s.close(closeErr)
+		}
+	}
+}
+
+/*
+*
+Take packets already queued, then wait for more until latency budget
+is spent or batch is full
+*/
+func (s *Socket) collect(batch []websocket.Frame, size int, latency time.Duration) []websocket.Frame {
+	for len(batch) < size {
+		select {
+		case msg := <-s.out:
+			batch = append(batch, frame(msg))
+			continue
+		default:
+		}
+		break
+	}
+
+	if latency <= 0 || len(batch) >= size {
+		return batch
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	for len(batch) < size {
+		select {
+		case msg := <-s.out:
+			batch = append(batch, frame(msg))
+		case <-timer.C:
+			return batch
+		case <-s.done:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+func (s *Socket) writeBatch(batch []websocket.Frame) error {
+	var err error
+	if len(batch) == 1 {
+		err = s.conn.WriteFrame(batch[0].MessageType, batch[0].Data)
+	} else {
+		err = s.conn.(websocket.BatchConn).WriteFrames(batch)
+	}
+
+	for _, f := range batch {
+		s.countWriteBytes(len(f.Data))
+	}
+
+	return err
+}
+
+func frame(msg interface{}) websocket.Frame {
+	switch packet := msg.(type) {
+	case string:
+		return websocket.Frame{MessageType: websocket.TextMessage, Data: []byte(packet)}
+	case []byte:
+		return websocket.Frame{MessageType: websocket.BinaryMessage, Data: packet}
+	}
+
+	return websocket.Frame{}
+}
+
+func (s *Socket) write(msg interface{}) error {
+	f := frame(msg)
+	err := s.conn.WriteFrame(f.MessageType, f.Data)
+	s.countWriteBytes(len(f.Data))
+
+	return err
+}
+
+func (s *Socket) countWriteBytes(n int) {
+	if s.writeBytes > maxRecordWriteBytes {
+		s.writeBytes = 0
+	}
+	s.writeBytes += n
+}
+
+func (s *Socket) schedulePing() {
+	interval, _ := s.tr.PingParams()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		if !s.IsAlive() {
+			return
+		}
+		s.push(protocol.PingMsg)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Baiguoshuai1/shadiaosocketio"
+	"github.com/Baiguoshuai1/shadiaosocketio/netpoll"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Measures memory and goroutines per idle connection of a transport:
+//
+//	go run ./examples/bench/idle -transport websocket -n 10000
+//	go run ./examples/bench/idle -transport netpoll -n 10000
+//
+// raise `ulimit -n` for big amounts of connections
+func main() {
+	transport := flag.String("transport", "websocket", "websocket or netpoll")
+	n := flag.Int("n", 10000, "amount of idle connections")
+	flag.Parse()
+
+	var tr websocket.Transport
+	switch *transport {
+	case "websocket":
+		tr = websocket.GetDefaultWebsocketTransport()
+	case "netpoll":
+		tr = netpoll.GetDefaultNetpollTransport()
+	default:
+		log.Fatalln("unknown transport:", *transport)
+	}
+
+	var connected int64
+	server := shadiaosocketio.NewServer(tr)
+	server.On(shadiaosocketio.OnConnection, func(c *shadiaosocketio.Channel) {
+		atomic.AddInt64(&connected, 1)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go http.Serve(ln, server)
+
+	// warm up worker pools and listeners before the first measurement
+	url := "ws://" + ln.Addr().String() + "/socket.io/?transport=websocket&EIO=4"
+	warmUp := dial(url)
+	time.Sleep(100 * time.Millisecond)
+	before := measure()
+
+	// raw connections without reading goroutines, so the client side costs almost nothing
+	conns := make([]net.Conn, 0, *n)
+	for i := 0; i < *n; i++ {
+		conns = append(conns, dial(url))
+	}
+	for atomic.LoadInt64(&connected) < int64(*n+1) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(time.Second)
+	after := measure()
+
+	fmt.Printf("transport:         %s\n", *transport)
+	fmt.Printf("connections:       %d\n", *n)
+	fmt.Printf("goroutines/conn:   %.2f\n", float64(after.goroutines-before.goroutines)/float64(*n))
+	fmt.Printf("heap bytes/conn:   %.0f\n", float64(after.heap-before.heap)/float64(*n))
+	fmt.Printf("stack bytes/conn:  %.0f\n", float64(after.stack-before.stack)/float64(*n))
+	fmt.Printf("total bytes/conn:  %.0f\n", float64(after.heap+after.stack-before.heap-before.stack)/float64(*n))
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	warmUp.Close()
+}
+
+/*
+*
+Dial raw websocket connection and connect it to the default namespace
+*/
+func dial(url string) net.Conn {
+	conn, _, _, err := ws.Dial(context.Background(), url)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := wsutil.WriteClientText(conn, []byte("40")); err != nil {
+		log.Fatalln(err)
+	}
+
+	return conn
+}
+
+type stats struct {
+	goroutines int
+	heap       uint64
+	stack      uint64
+}
+
+func measure() stats {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return stats{
+		goroutines: runtime.NumGoroutine(),
+		heap:       m.HeapInuse,
+		stack:      m.StackInuse,
+	}
+}
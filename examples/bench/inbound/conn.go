@@ -0,0 +1,62 @@
+/*
+*
+Allocations per inbound event, from the frame read by the transport to the handler call:
+
+	go test -bench . -benchmem ./examples/bench/inbound
+*/
+package inbound
+
+import (
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"net"
+	"sync"
+)
+
+/*
+*
+In memory connection feeding the same frame over and over, written frames are discarded
+*/
+type conn struct {
+	frames chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConn() *conn {
+	return &conn{
+		frames: make(chan []byte),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *conn) ReadFrame() (int, []byte, error) {
+	select {
+	case frame := <-c.frames:
+		// transports hand over a fresh slice for every frame
+		data := make([]byte, len(frame))
+		copy(data, frame)
+		return websocket.TextMessage, data, nil
+	case <-c.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (c *conn) WriteFrame(messageType int, data []byte) error {
+	return nil
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+}
@@ -68,7 +68,7 @@ func sendMessage(c *shadiaosocketio.Client, args ...interface{}) {
 func createClient() *shadiaosocketio.Client {
 	c, err := shadiaosocketio.Dial(
 		shadiaosocketio.GetUrl("localhost", 2233, false),
-		*websocket.GetDefaultWebsocketTransport())
+		websocket.GetDefaultWebsocketTransport())
 	if err != nil {
 		panic(err)
 	}
@@ -19,7 +19,7 @@ type Desc struct {
 }
 
 func main() {
-	server := shadiaosocketio.NewServer(*websocket.GetDefaultWebsocketTransport())
+	server := shadiaosocketio.NewServer(websocket.GetDefaultWebsocketTransport())
 
 	server.On(shadiaosocketio.OnConnection, func(c *shadiaosocketio.Channel) {
 		logWithTimestamp("connected! id:", c.Id(), c.LocalAddr().Network()+" "+c.LocalAddr().String()+
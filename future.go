@@ -0,0 +1,124 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"sync"
+)
+
+/*
+*
+Pending ack of emitted event, completed by the response, context cancellation
+or disconnection of the channel
+*/
+type AckFuture struct {
+	c  *Channel
+	id int
+
+	done   chan struct{}
+	once   sync.Once
+	result []interface{}
+	err    error
+}
+
+/*
+*
+Emit event and return future of its ack without blocking. The ack is failed
+with ctx error when ctx is done and with ErrorAckDisconnected on disconnection
+*/
+func (c *Channel) EmitWithAck(ctx context.Context, method string, args ...interface{}) *AckFuture {
+	msg := &protocol.Message{
+		Type:   protocol.EVENT,
+		AckId:  c.ack.getNextId(),
+		Method: method,
+		Nsp:    protocol.DefaultNsp,
+		Args:   args,
+	}
+
+	f := &AckFuture{
+		c:    c,
+		id:   msg.AckId,
+		done: make(chan struct{}),
+	}
+	c.ack.addWaiter(f.id, f)
+
+	// the channel may be closed before the waiter was added
+	if !c.IsAlive() {
+		f.cancel(ErrorAckDisconnected)
+		return f
+	}
+
+	if err := sendContext(ctx, c, msg); err != nil {
+		f.cancel(err)
+		return f
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				f.cancel(ctx.Err())
+			case <-f.done:
+			}
+		}()
+	}
+
+	return f
+}
+
+/*
+*
+Block until the ack is completed. Result is raw json values in text mode
+and decoded values in binary mode, error responses are returned as *AckError
+*/
+func (f *AckFuture) Wait() ([]interface{}, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+/*
+*
+Call cb in a new goroutine when the ack is completed
+*/
+func (f *AckFuture) Then(cb func(result []interface{}, err error)) {
+	go func() {
+		<-f.done
+		cb(f.result, f.err)
+	}()
+}
+
+/*
+*
+Closed when the ack is completed
+*/
+func (f *AckFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *AckFuture) resolve(result []interface{}) {
+	f.once.Do(func() {
+		f.result = result
+		if ackErr, err := f.c.methods.ackResultError(result); err != nil {
+			f.err = err
+		} else if ackErr != nil {
+			f.err = ackErr
+		}
+		close(f.done)
+	})
+}
+
+func (f *AckFuture) fail(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+/*
+*
+Remove the waiter and fail the ack, late response is dropped
+*/
+func (f *AckFuture) cancel(err error) {
+	f.c.ack.removeWaiter(f.id)
+	f.fail(err)
+}
@@ -4,14 +4,17 @@ go 1.20
 
 require (
 	github.com/buger/jsonparser v1.1.1
+	github.com/gobwas/ws v1.3.2
 	github.com/gorilla/websocket v1.5.0
 	github.com/json-iterator/go v1.1.12
+	github.com/modern-go/reflect2 v1.0.2
 	github.com/ugorji/go/codec v1.2.11
 	github.com/vmihailenco/msgpack/v5 v5.3.5
 )
 
 require (
+	github.com/gobwas/httphead v0.1.0 // indirect
+	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
-	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 )
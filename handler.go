@@ -1,12 +1,15 @@
 package shadiaosocketio
 
 import (
+	"encoding/json"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
 	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"github.com/buger/jsonparser"
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,6 +17,7 @@ const (
 	OnConnection    = "connection"
 	OnDisconnection = "disconnection"
 	OnError         = "error"
+	OnConnectError  = "connect_error"
 )
 
 /*
@@ -30,8 +34,20 @@ type methods struct {
 	messageHandlers     sync.Map
 	messageHandlersLock sync.RWMutex
 
+	dispatcher *dispatcher
+	ordered    bool
+	recoverAck bool
+	strictArgs bool
+
+	middlewares  []Middleware
+	eventTimeout time.Duration
+
+	ackErrorEncoder AckErrorEncoder
+	ackErrorDecoder AckErrorDecoder
+
 	onConnection    systemHandler
 	onDisconnection systemHandler
+	onConnectError  func(c *Channel, err *ConnectError)
 }
 
 func (m *methods) On(method string, f interface{}) error {
@@ -44,6 +60,31 @@ func (m *methods) On(method string, f interface{}) error {
 	return nil
 }
 
+/*
+*
+Same as On, but the handler is not waiting for previous events
+of the channel when events are ordered
+*/
+func (m *methods) OnParallel(method string, f interface{}) error {
+	c, err := newCaller(f)
+	if err != nil {
+		return err
+	}
+	c.Parallel = true
+
+	m.messageHandlers.Store(method, c)
+	return nil
+}
+
+/*
+*
+Reject events which amount of args doesn't match the handler signature, rejected
+events are reported to OnError handler and their acks are answered with AckError
+*/
+func (m *methods) SetStrictArgs(strict bool) {
+	m.strictArgs = strict
+}
+
 /*
 *
 Find message processing function associated with given method
@@ -69,89 +110,91 @@ func (m *methods) callLoopEvent(c *Channel, event string, args ...interface{}) {
 		return
 	}
 
-	f.callFunc(c, 0, args...)
+	f.callFunc(c.Context(), c, args...)
 }
 
-func (m *methods) getEventArgs(msg string) (string, []interface{}, error) {
-	rawArr := make([]interface{}, 0, 1)
+/*
+*
+Split json array of the packet into raw values without copying, strings keep their quotes.
+The first value is returned as event name too
+*/
+func parseEventArgs(data []byte) (string, []json.RawMessage, error) {
+	args := make([]json.RawMessage, 0, 4)
 	event := ""
-	c := 0
-
-	_, err := jsonparser.ArrayEach([]byte(msg), func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		c++
-		if c == 1 {
-			event = string(value)
-		}
 
+	_, err := jsonparser.ArrayEach(data, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
 		if dataType == jsonparser.String {
-			// string must add "\"\"" ps: "message"
-			marshal, err := utils.Json.Marshal("")
-			if err != nil {
-				return
+			if len(args) == 0 {
+				event = string(value)
 			}
-			v := make([]byte, 0, len(value)+2)
-			v = append(v, marshal[0])
-			v = append(v, value...)
-			v = append(v, marshal[1])
 
-			value = v
+			// offset of strings is counted from the closing quote, take the quotes around value
+			end := offset + len(value)
+			value = data[end-len(value)-2 : end]
 		}
 
-		rawArr = append(rawArr, value)
+		args = append(args, value)
 	})
 
 	if err != nil {
 		return "", nil, err
 	}
 
-	return event, rawArr, nil
+	return event, args, nil
 }
-func (m *methods) processIncomingMessageText(c *Channel, msg string) {
-	mType, err := strconv.Atoi(string(msg[0]))
-	if err != nil {
+
+func (m *methods) processIncomingMessageText(c *Channel, msg []byte) {
+	defer m.recoverEvent(c, "", -1, msg)
+
+	if len(msg) == 0 || msg[0] < '0' || msg[0] > '9' {
 		return
 	}
 
-	switch mType {
+	switch int(msg[0] - '0') {
 	case protocol.CONNECT:
-		sid, err := jsonparser.GetString([]byte(msg[1:]), "sid")
+		sid, err := jsonparser.GetString(msg[1:], "sid")
 		if err != nil {
 			return
 		}
 
-		c.header.Sid = sid
-		m.callLoopEvent(c, OnConnection)
+		m.connectClient(c, sid)
 	case protocol.DISCONNECT:
-		closeChannel(c, m)
+		c.socket.Close()
 	case protocol.EVENT:
+		if len(msg) < 2 {
+			return
+		}
+
 		// ack
-		if string(msg[1]) != "[" {
+		if msg[1] != '[' {
 			ackId, offset, err := parseAckId(msg[1:])
 			if err != nil || ackId < 0 {
 				return
 			}
 
-			event, args, err := m.getEventArgs(msg[1+offset:])
-			if err != nil {
+			event, args, err := parseEventArgs(msg[1+offset:])
+			if err != nil || len(args) == 0 {
 				return
 			}
+			defer m.recoverEvent(c, event, ackId, msg)
 
 			f, ok := m.findMethod(event)
 			if !ok {
 				return
 			}
-
-			if len(args) == 1 {
-				f.callFunc(c, 1)
+			if m.strictArgs && !f.acceptsArgs(len(args)-1) {
+				m.rejectEvent(c, event, ackId, msg, ErrorArgsMismatch)
 				return
 			}
 
-			arr := make([]interface{}, 0, 1)
-			ackRes := f.callFunc(c, 1, args[1:]...)
-
-			for _, v := range ackRes {
-				arr = append(arr, v.Interface())
+			ctx, cancel, err := m.eventContext(c, event)
+			if err != nil {
+				m.sendAckError(c, ackId, err)
+				return
 			}
+			defer cancel()
+
+			arr := m.ackArgs(f, f.callRaw(ctx, c, args[1:]))
 
 			r := &protocol.Message{
 				Type:  protocol.ACK,
@@ -160,24 +203,30 @@ func (m *methods) processIncomingMessageText(c *Channel, msg string) {
 				Args:  arr,
 			}
 
-			c.out <- protocol.GetMsgPacket(r)
+			c.sendPacket(protocol.GetMsgPacket(r))
 		} else {
-			event, args, err := m.getEventArgs(msg[1:])
-			if err != nil {
+			event, args, err := parseEventArgs(msg[1:])
+			if err != nil || len(args) == 0 {
 				return
 			}
+			defer m.recoverEvent(c, event, -1, msg)
 
 			f, ok := m.findMethod(event)
 			if !ok {
 				return
 			}
+			if m.strictArgs && !f.acceptsArgs(len(args)-1) {
+				m.rejectEvent(c, event, -1, msg, ErrorArgsMismatch)
+				return
+			}
 
-			if len(args) == 1 {
-				f.callFunc(c, 1)
+			ctx, cancel, err := m.eventContext(c, event)
+			if err != nil {
 				return
 			}
+			defer cancel()
 
-			f.callFunc(c, 1, args[1:]...)
+			f.callRaw(ctx, c, args[1:])
 		}
 	case protocol.ACK:
 		ackId, offset, err := parseAckId(msg[1:])
@@ -185,32 +234,108 @@ func (m *methods) processIncomingMessageText(c *Channel, msg string) {
 			return
 		}
 
-		if waiter, err := c.ack.getWaiter(ackId); err == nil {
-			_, args, err := m.getEventArgs(msg[1+offset:])
+		if _, err := c.ack.getWaiter(ackId); err == nil {
+			_, args, err := parseEventArgs(msg[1+offset:])
 			if err != nil {
 				return
 			}
-			waiter <- args
+
+			result := make([]interface{}, 0, len(args))
+			for _, arg := range args {
+				result = append(result, []byte(arg))
+			}
+
+			c.ack.resolve(ackId, result)
 		}
 	case protocol.CONNECT_ERROR:
-		closeChannel(c, m)
+		m.connectError(c, msg[1:])
 	case protocol.BINARY_EVENT:
 	case protocol.BINARY_ACK:
 	}
 }
 
-func (m *methods) processIncomingMessage(c *Channel, msg string) {
-	if !c.conn.GetUseBinaryMessage() {
-		go m.processIncomingMessageText(c, msg)
+/*
+*
+Called from the read loop of the channel, packets are passed to dispatcher.
+Acks are delivered right away, as their waiters may hold all the workers,
+CONNECT and DISCONNECT bypass dispatcher limits
+*/
+func (m *methods) processIncomingMessage(c *Channel, data []byte, binary bool) {
+	// in protocol v3 & text msg  ps: 0 or 1 or 2["message", ...]
+	// in protocol v4 & text msg  ps: 0 or 1 or 2["message", ...]
+	if !binary {
+		if len(data) == 0 {
+			return
+		}
+
+		switch int(data[0] - '0') {
+		case protocol.ACK, protocol.DISCONNECT:
+			m.processIncomingMessageText(c, data)
+			return
+		case protocol.CONNECT:
+			if c.server != nil {
+				c.server.connectChannel(c, data[1:])
+				return
+			}
+			go m.processIncomingMessageText(c, data)
+			return
+		}
+
+		ackId := getAckIdText(data)
+		ordered, ok := m.acceptEvent(c, getEventText(data), ackId)
+		if !ok {
+			return
+		}
+
+		m.dispatch(c, ordered, ackId, func() {
+			m.processIncomingMessageText(c, data)
+		})
+		return
+	}
+
+	// in protocol v4 & binary msg ps: {"type":0,"data":{"sid":"HWEr440000:1:R1CHyink:shadiao:101"},"nsp":"/","id":0}
+	msg, err := protocol.DecodeBinary(data)
+	if err != nil {
 		return
 	}
 
 	packet := &protocol.MsgPack{}
-	err := utils.Json.UnmarshalFromString(msg, &packet)
+	err = utils.Json.UnmarshalFromString(msg, &packet)
 	if err != nil {
 		return
 	}
 
+	switch packet.Type {
+	case protocol.ACK, protocol.DISCONNECT:
+		m.processIncomingPacket(c, packet, data)
+		return
+	case protocol.CONNECT:
+		if c.server != nil {
+			c.server.connectChannel(c, packet.Data)
+			return
+		}
+		go m.processIncomingPacket(c, packet, data)
+		return
+	}
+
+	ackId := -1
+	if packet.Type == protocol.EVENT {
+		ackId = packet.Id
+	}
+
+	ordered, ok := m.acceptEvent(c, getEventPacket(packet), ackId)
+	if !ok {
+		return
+	}
+
+	m.dispatch(c, ordered, ackId, func() {
+		m.processIncomingPacket(c, packet, data)
+	})
+}
+
+func (m *methods) processIncomingPacket(c *Channel, packet *protocol.MsgPack, raw []byte) {
+	defer m.recoverEvent(c, "", -1, raw)
+
 	switch packet.Type {
 	case protocol.CONNECT:
 		// server protocol 4 & binary msg -> client protocol 3 // 4{"type":0,"data":null,"nsp":"/","id":0}
@@ -225,10 +350,9 @@ func (m *methods) processIncomingMessage(c *Channel, msg string) {
 			return
 		}
 
-		c.header.Sid = reflect.ValueOf(packet.Data).MapIndex(reflect.ValueOf("sid")).Interface().(string)
-		m.callLoopEvent(c, OnConnection)
+		m.connectClient(c, reflect.ValueOf(packet.Data).MapIndex(reflect.ValueOf("sid")).Interface().(string))
 	case protocol.DISCONNECT:
-		closeChannel(c, m)
+		c.socket.Close()
 	case protocol.EVENT:
 		// ack
 		if packet.Id >= 0 {
@@ -237,22 +361,25 @@ func (m *methods) processIncomingMessage(c *Channel, msg string) {
 				return
 			}
 			event := data[0].(string)
+			defer m.recoverEvent(c, event, packet.Id, raw)
 
 			f, ok := m.findMethod(event)
 			if !ok {
 				return
 			}
-			if len(data) == 1 {
-				f.callFunc(c, 0)
+			if m.strictArgs && !f.acceptsArgs(len(data)-1) {
+				m.rejectEvent(c, event, packet.Id, raw, ErrorArgsMismatch)
 				return
 			}
 
-			arr := make([]interface{}, 0, 1)
-			ackRes := f.callFunc(c, 0, data[1:]...)
-
-			for _, v := range ackRes {
-				arr = append(arr, v.Interface())
+			ctx, cancel, err := m.eventContext(c, event)
+			if err != nil {
+				m.sendAckError(c, packet.Id, err)
+				return
 			}
+			defer cancel()
+
+			arr := m.ackArgs(f, f.callFunc(ctx, c, data[1:]...))
 
 			r := &protocol.Message{
 				Type:  protocol.ACK,
@@ -261,43 +388,133 @@ func (m *methods) processIncomingMessage(c *Channel, msg string) {
 				Args:  arr,
 			}
 
-			c.out <- protocol.GetMsgPacket(r)
+			c.sendPacket(protocol.GetMsgPacket(r))
 		} else {
 			data := packet.Data.([]interface{})
 			if len(data) == 0 {
 				return
 			}
 			event := data[0].(string)
+			defer m.recoverEvent(c, event, -1, raw)
 
 			f, ok := m.findMethod(event)
 			if !ok {
 				return
 			}
+			if m.strictArgs && !f.acceptsArgs(len(data)-1) {
+				m.rejectEvent(c, event, -1, raw, ErrorArgsMismatch)
+				return
+			}
+
+			ctx, cancel, err := m.eventContext(c, event)
+			if err != nil {
+				return
+			}
+			defer cancel()
 			if len(data) == 1 {
-				f.callFunc(c, 0)
+				f.callFunc(ctx, c)
 				return
 			}
 
-			f.callFunc(c, 0, data[1:]...)
+			f.callFunc(ctx, c, data[1:]...)
 		}
 	case protocol.ACK:
-		if waiter, err := c.ack.getWaiter(packet.Id); err == nil {
-			waiter <- packet.Data
-		}
+		result, _ := packet.Data.([]interface{})
+		c.ack.resolve(packet.Id, result)
 	case protocol.CONNECT_ERROR:
-		closeChannel(c, m)
+		m.connectError(c, packet.Data)
 	case protocol.BINARY_EVENT:
 	case protocol.BINARY_ACK:
 	}
 }
 
-func parseAckId(msg string) (int, int, error) {
+/*
+*
+Check if packet of the channel may be processed and should wait for previous packets.
+Server channels drop packets until CONNECT, acks of dropped events are answered with
+ErrorNotConnected. Packets received while OnConnection runs are queued after it
+*/
+func (m *methods) acceptEvent(c *Channel, event string, ackId int) (ordered bool, ok bool) {
+	if c.server == nil {
+		return m.ordered && m.isOrdered(event), true
+	}
+
+	switch atomic.LoadInt32(&c.connected) {
+	case channelIdle:
+		utils.Debug("[dispatch] packet before connect dropped, channel:", c.Id())
+		if ackId >= 0 {
+			m.sendAckError(c, ackId, ErrorNotConnected)
+		}
+		return false, false
+	case channelConnecting:
+		return true, true
+	}
+
+	return m.ordered && m.isOrdered(event), true
+}
+
+/*
+*
+Get event name of text packet without parsing args, empty for non event packets
+*/
+func getEventText(msg []byte) string {
+	if int(msg[0]-'0') != protocol.EVENT || len(msg) < 2 {
+		return ""
+	}
+
+	offset := 1
+	for offset < len(msg) && msg[offset] >= 48 && msg[offset] <= 57 {
+		offset++
+	}
+
+	event, err := jsonparser.GetString(msg[offset:], "[0]")
+	if err != nil {
+		return ""
+	}
+
+	return event
+}
+
+/*
+*
+Get event name of decoded binary packet, empty for non event packets
+*/
+func getEventPacket(packet *protocol.MsgPack) string {
+	if packet.Type != protocol.EVENT {
+		return ""
+	}
+
+	data, ok := packet.Data.([]interface{})
+	if !ok || len(data) == 0 {
+		return ""
+	}
+
+	event, _ := data[0].(string)
+	return event
+}
+
+/*
+*
+Get ack id of text event packet, -1 if the packet has none
+*/
+func getAckIdText(msg []byte) int {
+	if int(msg[0]-'0') != protocol.EVENT || len(msg) < 2 || msg[1] == '[' {
+		return -1
+	}
+
+	ackId, _, err := parseAckId(msg[1:])
+	if err != nil {
+		return -1
+	}
+
+	return ackId
+}
+
+func parseAckId(msg []byte) (int, int, error) {
 	var offset = 0
-	var id = ""
-	for msg[offset] >= 48 && msg[offset] <= 57 {
-		id = id + string(msg[offset])
+	for offset < len(msg) && msg[offset] >= 48 && msg[offset] <= 57 {
 		offset++
 	}
-	ret, err := strconv.Atoi(id)
+	ret, err := strconv.Atoi(string(msg[:offset]))
 	return ret, offset, err
 }
@@ -0,0 +1,108 @@
+package shadiaosocketio
+
+import (
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	transportWebsocket = "websocket"
+)
+
+/*
+*
+Details of the connection handshake, on the server side Auth is the payload
+of CONNECT packet sent by the client in protocol v4
+*/
+type Handshake struct {
+	Time time.Time
+	// Issued is Time in unix milliseconds
+	Issued  int64
+	Address string
+	XDomain bool
+	Secure  bool
+	URL     string
+	Query   url.Values
+	Headers http.Header
+	Auth    map[string]interface{}
+	// EIO is engine.io protocol version
+	EIO       int
+	Transport string
+}
+
+/*
+*
+Get handshake details of the channel, Auth is filled once the channel is connected
+*/
+func (c *Channel) Handshake() *Handshake {
+	return &c.handshake
+}
+
+func newServerHandshake(r *http.Request, remoteAddr string, protocolVersion int) Handshake {
+	now := time.Now()
+	h := Handshake{
+		Time:      now,
+		Issued:    now.UnixMilli(),
+		Address:   remoteAddr,
+		EIO:       protocolVersion,
+		Transport: transportWebsocket,
+		Query:     url.Values{},
+		Headers:   http.Header{},
+	}
+	if r == nil {
+		return h
+	}
+
+	h.XDomain = r.Header.Get("Origin") != ""
+	h.Secure = r.TLS != nil
+	h.URL = r.URL.RequestURI()
+	h.Query = r.URL.Query()
+	h.Headers = r.Header
+
+	if eio, err := strconv.Atoi(h.Query.Get("EIO")); err == nil {
+		h.EIO = eio
+	}
+	if transport := h.Query.Get("transport"); transport != "" {
+		h.Transport = transport
+	}
+
+	return h
+}
+
+func newClientHandshake(rawUrl string, protocolVersion int) Handshake {
+	h := Handshake{
+		URL:       rawUrl,
+		EIO:       protocolVersion,
+		Transport: transportWebsocket,
+		Query:     url.Values{},
+		Headers:   http.Header{},
+	}
+
+	if u, err := url.Parse(rawUrl); err == nil {
+		h.Secure = u.Scheme == "wss" || u.Scheme == "https"
+		h.Query = u.Query()
+	}
+
+	return h
+}
+
+/*
+*
+Parse auth payload of CONNECT packet, text payload is json object, binary one is decoded value
+*/
+func parseAuth(payload interface{}) map[string]interface{} {
+	data, err := ackValueJson(payload)
+	if err != nil || len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+
+	auth := make(map[string]interface{})
+	if err := utils.Json.Unmarshal(data, &auth); err != nil {
+		return nil
+	}
+
+	return auth
+}
@@ -0,0 +1,144 @@
+package netpoll
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/gobwas/ws"
+)
+
+var (
+	ErrorMessageTooLarge = errors.New("message is too large")
+)
+
+/*
+*
+Incremental frame parser of polled connection, bytes of incomplete frames
+and fragments of incomplete messages are kept until the next read
+*/
+type frameParser struct {
+	state   ws.State
+	maxSize int
+
+	buf     []byte
+	message []byte
+	op      ws.OpCode
+}
+
+/*
+*
+Parse frames of data together with the bytes left from the previous call. Complete messages
+are passed to onMessage, control frames to onControl, payloads are owned by the callee
+*/
+func (p *frameParser) feed(data []byte, onMessage func(op ws.OpCode, data []byte),
+	onControl func(hdr ws.Header, payload []byte) error) error {
+
+	buf := data
+	owned := len(p.buf) > 0
+	if owned {
+		buf = append(p.buf, data...)
+	}
+	total := len(buf)
+
+	for {
+		hdrSize, length, ok := frameSize(buf)
+		if !ok {
+			break
+		}
+		if p.maxSize > 0 && length+int64(len(p.message)) > int64(p.maxSize) {
+			return ErrorMessageTooLarge
+		}
+		if int64(len(buf)-hdrSize) < length {
+			break
+		}
+
+		hdr, err := ws.ReadHeader(bytes.NewReader(buf[:hdrSize]))
+		if err != nil {
+			return err
+		}
+		payload := buf[hdrSize : hdrSize+int(length)]
+		buf = buf[hdrSize+int(length):]
+
+		if err := ws.CheckHeader(hdr, p.state); err != nil {
+			return err
+		}
+		if hdr.Masked {
+			ws.Cipher(payload, hdr.Mask, 0)
+		}
+
+		if hdr.OpCode.IsControl() {
+			if err := onControl(hdr, payload); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// continuation frames are expected until the final one
+		if hdr.OpCode == ws.OpContinuation {
+			p.message = append(p.message, payload...)
+		} else {
+			p.op = hdr.OpCode
+			p.message = make([]byte, 0, len(payload))
+			p.message = append(p.message, payload...)
+		}
+
+		if !hdr.Fin {
+			p.state = p.state.Set(ws.StateFragmented)
+			continue
+		}
+
+		p.state = p.state.Clear(ws.StateFragmented)
+		message := p.message
+		p.message = nil
+		onMessage(p.op, message)
+	}
+
+	// data may be the shared read buffer, the rest is copied. Bytes of own buffer are moved
+	// only when frames were taken from it, so a large frame isn't copied on every read
+	switch {
+	case len(buf) == 0:
+		p.buf = nil
+	case !owned:
+		p.buf = append(make([]byte, 0, len(buf)), buf...)
+	case len(buf) < total:
+		p.buf = append(p.buf[:0], buf...)
+	default:
+		p.buf = buf
+	}
+
+	return nil
+}
+
+/*
+*
+Get size of frame header and payload length, ok is false if the header is incomplete
+*/
+func frameSize(buf []byte) (hdrSize int, length int64, ok bool) {
+	if len(buf) < 2 {
+		return 0, 0, false
+	}
+
+	hdrSize = 2
+	length = int64(buf[1] & 0x7f)
+	switch length {
+	case 126:
+		hdrSize += 2
+	case 127:
+		hdrSize += 8
+	}
+	if buf[1]&0x80 != 0 {
+		hdrSize += 4
+	}
+	if len(buf) < hdrSize {
+		return 0, 0, false
+	}
+
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(buf[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(buf[2:10]) & (1<<63 - 1))
+	}
+
+	return hdrSize, length, true
+}
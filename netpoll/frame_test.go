@@ -0,0 +1,203 @@
+package netpoll
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+type parsed struct {
+	op   ws.OpCode
+	data string
+}
+
+/*
+*
+Encode frame the way clients send it, masked
+*/
+func clientFrame(t *testing.T, fin bool, op ws.OpCode, payload string) []byte {
+	t.Helper()
+
+	frame := ws.NewFrame(op, fin, []byte(payload))
+	frame = ws.MaskFrameInPlace(frame)
+
+	var buf bytes.Buffer
+	if err := ws.WriteFrame(&buf, frame); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func feedAll(p *frameParser, chunks [][]byte) ([]parsed, []ws.OpCode, error) {
+	messages := make([]parsed, 0)
+	controls := make([]ws.OpCode, 0)
+
+	for _, chunk := range chunks {
+		err := p.feed(chunk, func(op ws.OpCode, data []byte) {
+			messages = append(messages, parsed{op, string(data)})
+		}, func(hdr ws.Header, payload []byte) error {
+			controls = append(controls, hdr.OpCode)
+			return nil
+		})
+		if err != nil {
+			return messages, controls, err
+		}
+	}
+
+	return messages, controls, nil
+}
+
+/*
+*
+Split data into chunks of size bytes, the last one may be shorter
+*/
+func split(data []byte, size int) [][]byte {
+	chunks := make([][]byte, 0)
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+
+	return append(chunks, data)
+}
+
+func TestFrameParser(t *testing.T) {
+	large := string(bytes.Repeat([]byte("x"), 70000))
+	medium := string(bytes.Repeat([]byte("y"), 300))
+
+	var fragmented []byte
+	fragmented = append(fragmented, clientFrame(t, false, ws.OpText, "42[\"ev\",")...)
+	fragmented = append(fragmented, clientFrame(t, true, ws.OpPing, "")...)
+	fragmented = append(fragmented, clientFrame(t, false, ws.OpContinuation, "\""+medium)...)
+	fragmented = append(fragmented, clientFrame(t, true, ws.OpContinuation, "\"]")...)
+	fragmented = append(fragmented, clientFrame(t, true, ws.OpBinary, "bin")...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		messages []parsed
+		controls []ws.OpCode
+	}{
+		{
+			name:     "single frame",
+			data:     clientFrame(t, true, ws.OpText, "42[\"ev\"]"),
+			messages: []parsed{{ws.OpText, "42[\"ev\"]"}},
+		},
+		{
+			name: "frames in one read",
+			data: append(clientFrame(t, true, ws.OpText, "2"), clientFrame(t, true, ws.OpText, "3")...),
+			messages: []parsed{
+				{ws.OpText, "2"},
+				{ws.OpText, "3"},
+			},
+		},
+		{
+			name:     "16 bit length",
+			data:     clientFrame(t, true, ws.OpText, medium),
+			messages: []parsed{{ws.OpText, medium}},
+		},
+		{
+			name:     "64 bit length",
+			data:     clientFrame(t, true, ws.OpBinary, large),
+			messages: []parsed{{ws.OpBinary, large}},
+		},
+		{
+			name: "fragmented with control frame",
+			data: fragmented,
+			messages: []parsed{
+				{ws.OpText, "42[\"ev\",\"" + medium + "\"]"},
+				{ws.OpBinary, "bin"},
+			},
+			controls: []ws.OpCode{ws.OpPing},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{len(tt.data), 1, 2, 3, 7, 1000} {
+			p := &frameParser{state: ws.StateServerSide}
+			messages, controls, err := feedAll(p, split(append([]byte(nil), tt.data...), size))
+			if err != nil {
+				t.Fatalf("%s, reads of %d: %v", tt.name, size, err)
+			}
+			if len(messages) != len(tt.messages) {
+				t.Fatalf("%s, reads of %d: %d messages, want %d", tt.name, size, len(messages), len(tt.messages))
+			}
+			for i := range messages {
+				if messages[i] != tt.messages[i] {
+					t.Errorf("%s, reads of %d: message %d = %v %.20q, want %v %.20q", tt.name, size, i,
+						messages[i].op, messages[i].data, tt.messages[i].op, tt.messages[i].data)
+				}
+			}
+			if len(controls) != len(tt.controls) {
+				t.Errorf("%s, reads of %d: %d control frames, want %d", tt.name, size, len(controls), len(tt.controls))
+			}
+			if p.buf != nil || p.message != nil || p.state.Fragmented() {
+				t.Errorf("%s, reads of %d: parser is not reset", tt.name, size)
+			}
+		}
+	}
+}
+
+func TestFrameParserErrors(t *testing.T) {
+	unmasked := ws.MustCompileFrame(ws.NewTextFrame([]byte("2")))
+
+	tests := []struct {
+		name    string
+		maxSize int
+		data    []byte
+		err     error
+	}{
+		{
+			name: "unexpected continuation",
+			data: clientFrame(t, true, ws.OpContinuation, "x"),
+			err:  ws.ErrProtocolContinuationUnexpected,
+		},
+		{
+			name: "expected continuation",
+			data: append(clientFrame(t, false, ws.OpText, "x"), clientFrame(t, true, ws.OpText, "y")...),
+			err:  ws.ErrProtocolContinuationExpected,
+		},
+		{
+			name: "unmasked",
+			data: unmasked,
+			err:  ws.ErrProtocolMaskRequired,
+		},
+		{
+			name:    "message too large",
+			maxSize: 10,
+			data:    clientFrame(t, true, ws.OpText, "0123456789a"),
+			err:     ErrorMessageTooLarge,
+		},
+		{
+			name:    "fragments too large",
+			maxSize: 10,
+			data:    append(clientFrame(t, false, ws.OpText, "012345"), clientFrame(t, true, ws.OpContinuation, "6789a")...),
+			err:     ErrorMessageTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		p := &frameParser{state: ws.StateServerSide, maxSize: tt.maxSize}
+		if _, _, err := feedAll(p, [][]byte{tt.data}); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestFrameSize(t *testing.T) {
+	frame := clientFrame(t, true, ws.OpText, string(bytes.Repeat([]byte("x"), 300)))
+
+	for i := 0; i < 8; i++ {
+		if _, _, ok := frameSize(frame[:i]); ok {
+			t.Errorf("frameSize of %d bytes is complete", i)
+		}
+	}
+
+	hdrSize, length, ok := frameSize(frame)
+	if !ok || hdrSize != 8 || length != 300 {
+		t.Errorf("frameSize = %d, %d, %v, want 8, 300, true", hdrSize, length, ok)
+	}
+}
@@ -0,0 +1,494 @@
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultReactorQueueSize = 1024 * 16
+	DefaultMaxMessageSize   = 1024 * 1024 * 16
+
+	readBufferSize = 1024 * 32
+)
+
+var (
+	batchBuffers = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+	readBuffers = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, readBufferSize)
+			return &buf
+		},
+	}
+)
+
+var (
+	ErrorPollNotSupported = errors.New("netpoll is not supported on this platform")
+	ErrorNotSyscallConn   = errors.New("connection has no file descriptor")
+)
+
+/*
+*
+gobwas/ws based Conn, able to be driven by epoll reactor
+*/
+type Connection struct {
+	conn      net.Conn
+	pending   *bytes.Reader
+	state     ws.State
+	transport *Transport
+
+	writeLock sync.Mutex
+	// readLock orders reads of subsequent wake ups handled by different workers
+	readLock sync.Mutex
+
+	fd      int
+	raw     syscall.RawConn
+	parser  frameParser
+	poller  *poller
+	handler func(messageType int, data []byte, err error)
+}
+
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Connection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+/*
+*
+Read from the bytes left after the handshake first, then from the connection
+*/
+func (c *Connection) Read(p []byte) (int, error) {
+	if c.pending != nil && c.pending.Len() > 0 {
+		return c.pending.Read(p)
+	}
+
+	return c.conn.Read(p)
+}
+
+func (c *Connection) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.conn.Write(p)
+}
+
+/*
+*
+Read single frame, ok is false for control frames
+*/
+func (c *Connection) readFrame() (messageType int, data []byte, ok bool, err error) {
+	err = c.conn.SetReadDeadline(time.Now().Add(c.transport.ReceiveTimeout))
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	controlHandler := wsutil.ControlFrameHandler(c, c.state)
+	r := wsutil.Reader{
+		Source:         c,
+		State:          c.state,
+		OnIntermediate: controlHandler,
+	}
+
+	hdr, err := r.NextFrame()
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if hdr.OpCode.IsControl() {
+		return 0, nil, false, controlHandler(hdr, &r)
+	}
+
+	data, err = websocket.ReadMessage(&r)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	utils.Debug("[ReadFrame]", data)
+	if hdr.OpCode == ws.OpBinary {
+		return websocket.BinaryMessage, data, true, nil
+	}
+
+	return websocket.TextMessage, data, true, nil
+}
+
+func (c *Connection) ReadFrame() (messageType int, data []byte, err error) {
+	for {
+		messageType, data, ok, err := c.readFrame()
+		if err != nil || ok {
+			return messageType, data, err
+		}
+	}
+}
+
+func (c *Connection) WriteFrame(messageType int, data []byte) error {
+	utils.Debug("[WriteFrame]", data)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.transport.SendTimeout))
+	if err != nil {
+		return err
+	}
+
+	op := ws.OpText
+	if messageType == websocket.BinaryMessage {
+		op = ws.OpBinary
+	}
+
+	return wsutil.WriteMessage(c.conn, c.state, op, data)
+}
+
+/*
+*
+Encode frames of the batch into pooled buffer and write them at once
+*/
+func (c *Connection) WriteFrames(frames []websocket.Frame) error {
+	buf := batchBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		batchBuffers.Put(buf)
+	}()
+
+	for _, frame := range frames {
+		utils.Debug("[WriteFrame]", frame.Data)
+
+		op := ws.OpText
+		if frame.MessageType == websocket.BinaryMessage {
+			op = ws.OpBinary
+		}
+		if err := wsutil.WriteMessage(buf, c.state, op, frame.Data); err != nil {
+			return err
+		}
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.transport.SendTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(buf.Bytes())
+	return err
+}
+
+/*
+*
+Register connection in the transport reactor, frames are read
+and passed to handler by the worker pool
+*/
+func (c *Connection) Poll(handler func(messageType int, data []byte, err error)) error {
+	p, err := c.transport.getPoller()
+	if err != nil {
+		return err
+	}
+
+	sc, ok := c.conn.(syscall.Conn)
+	if !ok {
+		return ErrorNotSyscallConn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	err = raw.Control(func(fd uintptr) {
+		c.fd = int(fd)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.raw = raw
+	c.parser = frameParser{state: c.state, maxSize: c.transport.MaxMessageSize}
+	c.handler = handler
+	c.poller = p
+
+	if c.pending != nil && c.pending.Len() > 0 {
+		// bytes read with the handshake will never wake up epoll
+		if err := p.add(c, false); err != nil {
+			return err
+		}
+		p.submit(c)
+		return nil
+	}
+
+	return p.add(c, true)
+}
+
+/*
+*
+Called by the worker pool when the connection is readable. Only available bytes are read,
+incomplete frames are kept by the parser until the connection is readable again
+*/
+func (c *Connection) handle() {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	buf := readBuffers.Get().(*[]byte)
+	n, err := c.readAvailable(*buf)
+	if err == nil && n > 0 {
+		err = c.parser.feed((*buf)[:n], c.onMessage, c.onControl)
+	}
+	readBuffers.Put(buf)
+
+	if err != nil {
+		c.poller.remove(c)
+		c.handler(0, nil, err)
+		return
+	}
+
+	if c.pending != nil && c.pending.Len() > 0 {
+		c.poller.submit(c)
+		return
+	}
+	if err := c.poller.rearm(c); err != nil {
+		c.poller.remove(c)
+		c.handler(0, nil, err)
+	}
+}
+
+func (c *Connection) readAvailable(p []byte) (int, error) {
+	if c.pending != nil && c.pending.Len() > 0 {
+		return c.pending.Read(p)
+	}
+
+	return readAvailable(c.raw, p)
+}
+
+func (c *Connection) onMessage(op ws.OpCode, data []byte) {
+	utils.Debug("[ReadFrame]", data)
+	if op == ws.OpBinary {
+		c.handler(websocket.BinaryMessage, data, nil)
+		return
+	}
+
+	c.handler(websocket.TextMessage, data, nil)
+}
+
+/*
+*
+Answer ping and close frames, payload is already unmasked
+*/
+func (c *Connection) onControl(hdr ws.Header, payload []byte) error {
+	handler := wsutil.ControlHandler{
+		Src:                 bytes.NewReader(payload),
+		Dst:                 c,
+		State:               c.state,
+		DisableSrcCiphering: true,
+	}
+
+	return handler.Handle(hdr)
+}
+
+func (c *Connection) Close() error {
+	if c.poller != nil {
+		c.poller.remove(c)
+	}
+
+	return c.conn.Close()
+}
+
+/*
+*
+gobwas/ws based Transport, connections are served by epoll reactor
+and a worker pool instead of a goroutine per connection
+*/
+type Transport struct {
+	websocket.Params
+	websocket.DialParams
+
+	ReceiveTimeout time.Duration
+	SendTimeout    time.Duration
+
+	// Workers is the amount of goroutines reading frames, ReactorQueueSize is the amount
+	// of readable connections waiting for a free worker
+	Workers          int
+	ReactorQueueSize int
+
+	// MaxMessageSize limits bytes buffered for a message of polled connection, 0 is unlimited
+	MaxMessageSize int
+
+	UnsecureTLS bool
+	TLSConfig   *tls.Config
+
+	RequestHeader http.Header
+	Cors          websocket.Cors
+
+	pollerOnce sync.Once
+	poller     *poller
+	pollerErr  error
+}
+
+func (t *Transport) getPoller() (*poller, error) {
+	t.pollerOnce.Do(func() {
+		t.poller, t.pollerErr = newPoller(t.Workers, t.ReactorQueueSize)
+	})
+
+	return t.poller, t.pollerErr
+}
+
+func (t *Transport) Connect(url string) (websocket.Conn, error) {
+	return t.ConnectContext(context.Background(), url, nil)
+}
+
+func (t *Transport) ConnectContext(ctx context.Context, rawUrl string, header http.Header) (websocket.Conn, error) {
+	tlsCfg := t.TLSConfig
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{InsecureSkipVerify: t.UnsecureTLS}
+	}
+	dial, err := t.ContextDialer(rawUrl, tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := t.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = t.SendTimeout
+	}
+
+	// cookies are stored by http url of the connection
+	cookieUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	cookieUrl.Scheme = strings.Replace(cookieUrl.Scheme, "ws", "http", 1)
+
+	requestHeader := websocket.MergeHeader(t.RequestHeader, header)
+	setCookies := http.Header{}
+	if t.Jar != nil {
+		cookies := make([]string, 0)
+		for _, cookie := range t.Jar.Cookies(cookieUrl) {
+			cookies = append(cookies, cookie.String())
+		}
+		if len(cookies) > 0 {
+			requestHeader.Set("Cookie", strings.Join(cookies, "; "))
+		}
+	}
+
+	dialer := ws.Dialer{
+		Header:    ws.HandshakeHeaderHTTP(requestHeader),
+		TLSConfig: tlsCfg,
+		Timeout:   timeout,
+		NetDial:   dial,
+		OnHeader: func(key, value []byte) error {
+			if t.Jar != nil && strings.EqualFold(string(key), "Set-Cookie") {
+				setCookies.Add("Set-Cookie", string(value))
+			}
+			return nil
+		},
+	}
+
+	conn, br, _, err := dialer.Dial(ctx, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if t.Jar != nil && len(setCookies) > 0 {
+		t.Jar.SetCookies(cookieUrl, (&http.Response{Header: setCookies}).Cookies())
+	}
+
+	c := &Connection{conn: conn, state: ws.StateClientSide, transport: t}
+	if br != nil {
+		buffered := make([]byte, br.Buffered())
+		_, _ = br.Read(buffered)
+		c.pending = bytes.NewReader(buffered)
+		ws.PutReader(br)
+	}
+
+	return c, nil
+}
+
+func (t *Transport) HandleConnection(
+	w http.ResponseWriter, r *http.Request) (websocket.Conn, error) {
+
+	if r.Method != "GET" {
+		return nil, websocket.ErrorMethodNotAllowed
+	}
+	if !t.Cors.CheckOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, websocket.ErrorOriginNotAllowed
+	}
+
+	// hijacked response has only headers passed to the upgrader
+	header := w.Header().Clone()
+	for key, el := range t.Cors.AllowedHeaders {
+		header.Set(key, el)
+	}
+
+	upgrade := ws.HTTPUpgrader{
+		Timeout: t.SendTimeout,
+		Header:  header,
+	}
+
+	conn, rw, _, err := upgrade.Upgrade(r, w)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{conn: conn, state: ws.StateServerSide, transport: t}
+	if rw != nil && rw.Reader.Buffered() > 0 {
+		buffered := make([]byte, rw.Reader.Buffered())
+		_, _ = rw.Reader.Read(buffered)
+		c.pending = bytes.NewReader(buffered)
+	}
+
+	return c, nil
+}
+
+func (t *Transport) GetCors() *websocket.Cors {
+	return &t.Cors
+}
+
+/*
+*
+Connection is served by the reactor, no additional processing required
+*/
+func (t *Transport) Serve(w http.ResponseWriter, r *http.Request) {}
+
+/*
+*
+Returns netpoll transport with default params
+*/
+func GetDefaultNetpollTransport() *Transport {
+	return &Transport{
+		Params: websocket.Params{
+			Protocol:       protocol.Protocol4,
+			PingInterval:   websocket.WsDefaultPingInterval,
+			PingTimeout:    websocket.WsDefaultPingTimeout,
+			BinaryMessage:  false,
+			QueueSize:      websocket.WsDefaultQueueSize,
+			WriteBatchSize: websocket.WsDefaultWriteBatchSize,
+		},
+		ReceiveTimeout:   websocket.WsDefaultReceiveTimeout,
+		SendTimeout:      websocket.WsDefaultSendTimeout,
+		Workers:          runtime.NumCPU() * 4,
+		ReactorQueueSize: DefaultReactorQueueSize,
+		MaxMessageSize:   DefaultMaxMessageSize,
+		Cors: websocket.Cors{
+			Origin:      "*",
+			Credentials: false,
+		},
+	}
+}
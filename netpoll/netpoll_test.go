@@ -0,0 +1,129 @@
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+/*
+*
+Server polling upgraded connections with a single worker, received messages are sent to messages
+*/
+func newPollServer(t *testing.T, messages chan<- string) string {
+	tr := GetDefaultNetpollTransport()
+	tr.Workers = 1
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := tr.HandleConnection(w, r)
+		if err != nil {
+			return
+		}
+
+		err = conn.(*Connection).Poll(func(messageType int, data []byte, err error) {
+			if err != nil {
+				conn.Close()
+				return
+			}
+			messages <- string(data)
+		})
+		if errors.Is(err, ErrorPollNotSupported) {
+			conn.Close()
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return "ws://" + strings.TrimPrefix(ts.URL, "http://")
+}
+
+func dialRaw(t *testing.T, url string) net.Conn {
+	conn, br, _, err := ws.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br != nil {
+		ws.PutReader(br)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+		return ""
+	}
+}
+
+func TestPollFragmentedMessage(t *testing.T) {
+	messages := make(chan string, 4)
+	url := newPollServer(t, messages)
+
+	// gorilla client splits messages larger than its write buffer into fragments
+	tr := websocket.GetDefaultWebsocketTransport()
+	tr.BufferSize = 1024
+	conn, err := tr.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	event := `42["ev","` + strings.Repeat("x", 10*1024) + `"]`
+	if err := conn.WriteFrame(websocket.TextMessage, []byte(event)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, messages); msg != event {
+		t.Errorf("message of %d bytes received, want %d", len(msg), len(event))
+	}
+}
+
+func TestPollPartialFrame(t *testing.T) {
+	messages := make(chan string, 4)
+	url := newPollServer(t, messages)
+
+	var frame strings.Builder
+	w := wsutil.NewWriter(&frame, ws.StateClientSide, ws.OpText)
+	_, _ = w.Write([]byte("42[\"slow\"]"))
+	_ = w.Flush()
+
+	// the only worker must not wait for the rest of the frame
+	slow := dialRaw(t, url)
+	if _, err := slow.Write([]byte(frame.String()[:3])); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	fast := dialRaw(t, url)
+	if err := wsutil.WriteClientText(fast, []byte("42[\"fast\"]")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, messages); msg != "42[\"fast\"]" {
+		t.Errorf("message = %q, want fast one", msg)
+	}
+
+	if _, err := slow.Write([]byte(frame.String()[3:])); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, messages); msg != "42[\"slow\"]" {
+		t.Errorf("message = %q, want slow one", msg)
+	}
+}
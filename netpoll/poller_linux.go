@@ -0,0 +1,177 @@
+//go:build linux
+
+package netpoll
+
+import (
+	"io"
+	"log"
+	"sync"
+	"syscall"
+)
+
+const (
+	pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+	pollBatch  = 256
+)
+
+/*
+*
+epoll reactor, readable connections are passed to the worker pool.
+EPOLLONESHOT guarantees that a connection is handled by a single worker at a time
+*/
+type poller struct {
+	fd int
+
+	conns     map[int]*Connection
+	connsLock sync.RWMutex
+
+	tasks chan *Connection
+}
+
+func newPoller(workers, reactorQueueSize int) (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
+
+	p := &poller{
+		fd:    fd,
+		conns: make(map[int]*Connection),
+		tasks: make(chan *Connection, reactorQueueSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.wait()
+
+	return p, nil
+}
+
+/*
+*
+Add connection to the reactor, armed connections are reported when readable
+*/
+func (p *poller) add(c *Connection, armed bool) error {
+	p.connsLock.Lock()
+	p.conns[c.fd] = c
+	p.connsLock.Unlock()
+
+	var events uint32 = syscall.EPOLLONESHOT
+	if armed {
+		events = pollEvents
+	}
+
+	err := syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, c.fd, &syscall.EpollEvent{
+		Events: events,
+		Fd:     int32(c.fd),
+	})
+	if err != nil {
+		p.connsLock.Lock()
+		delete(p.conns, c.fd)
+		p.connsLock.Unlock()
+	}
+
+	return err
+}
+
+/*
+*
+Report connection once more when readable
+*/
+func (p *poller) rearm(c *Connection) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, c.fd, &syscall.EpollEvent{
+		Events: pollEvents,
+		Fd:     int32(c.fd),
+	})
+}
+
+func (p *poller) remove(c *Connection) {
+	p.connsLock.Lock()
+	defer p.connsLock.Unlock()
+
+	if cur, ok := p.conns[c.fd]; !ok || cur != c {
+		return
+	}
+
+	delete(p.conns, c.fd)
+	_ = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+}
+
+/*
+*
+Handle connection by the worker pool without waiting for epoll
+*/
+func (p *poller) submit(c *Connection) {
+	select {
+	case p.tasks <- c:
+	default:
+		go func() {
+			p.tasks <- c
+		}()
+	}
+}
+
+func (p *poller) wait() {
+	events := make([]syscall.EpollEvent, pollBatch)
+	ready := make([]*Connection, 0, pollBatch)
+	for {
+		n, err := syscall.EpollWait(p.fd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Println("netpoll wait: ", err)
+			return
+		}
+
+		ready = ready[:0]
+		p.connsLock.RLock()
+		for i := 0; i < n; i++ {
+			if c, ok := p.conns[int(events[i].Fd)]; ok {
+				ready = append(ready, c)
+			}
+		}
+		p.connsLock.RUnlock()
+
+		for _, c := range ready {
+			p.tasks <- c
+		}
+	}
+}
+
+func (p *poller) work() {
+	for c := range p.tasks {
+		c.handle()
+	}
+}
+
+/*
+*
+Read bytes available on the connection without blocking, n is 0 if there are none
+*/
+func readAvailable(raw syscall.RawConn, buf []byte) (n int, err error) {
+	var readErr error
+	err = raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buf)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case readErr == syscall.EAGAIN || readErr == syscall.EINTR:
+		return 0, nil
+	case readErr != nil:
+		return 0, readErr
+	case n == 0:
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
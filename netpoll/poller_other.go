@@ -0,0 +1,31 @@
+//go:build !linux
+
+package netpoll
+
+import "syscall"
+
+/*
+*
+epoll is not available, connections fall back to read loops
+*/
+type poller struct{}
+
+func newPoller(workers, reactorQueueSize int) (*poller, error) {
+	return nil, ErrorPollNotSupported
+}
+
+func (p *poller) add(c *Connection, armed bool) error {
+	return ErrorPollNotSupported
+}
+
+func (p *poller) rearm(c *Connection) error {
+	return ErrorPollNotSupported
+}
+
+func (p *poller) remove(c *Connection) {}
+
+func (p *poller) submit(c *Connection) {}
+
+func readAvailable(raw syscall.RawConn, buf []byte) (int, error) {
+	return 0, ErrorPollNotSupported
+}
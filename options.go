@@ -0,0 +1,170 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPath             = "/socket.io/"
+	DefaultHandshakeTimeout = 20 * time.Second
+)
+
+var (
+	ErrorInvalidUrl  = errors.New("invalid url, ws, wss, http or https scheme expected")
+	ErrorInvalidPath = errors.New("invalid path, absolute path expected")
+)
+
+/*
+*
+Options of the client connection
+*/
+type ClientOptions struct {
+	// Transport is the default websocket transport if not set
+	Transport websocket.Transport
+
+	// Path is the path the server is mounted on, DefaultPath if not set
+	Path  string
+	Query url.Values
+	// Header is merged into the request header of the transport, if it implements websocket.ContextTransport
+	Header http.Header
+	// Auth is the payload of CONNECT packet in protocol v4
+	Auth map[string]interface{}
+	// Protocol is engine.io protocol, protocol of the transport if not set
+	Protocol int
+
+	// HandshakeTimeout limits every Connect attempt until the namespace connection, DefaultHandshakeTimeout if not set
+	HandshakeTimeout time.Duration
+
+	// Refresh is called before every connection attempt, e.g. to get a fresh token. lastErr is the error
+	// of the previous Connect attempt, *ConnectError if the server refused the connection
+	Refresh func(ctx context.Context, lastErr error) (ConnectParams, error)
+	// Attempts is the max amount of Connect attempts, 1 if not set
+	Attempts   int
+	RetryDelay time.Duration
+}
+
+/*
+*
+Credentials of the connection attempt, they are merged over Header, Query and Auth of options
+*/
+type ConnectParams struct {
+	Header http.Header
+	Query  url.Values
+	Auth   map[string]interface{}
+}
+
+/*
+*
+Build url of the server from base url like "https://example.com:2233", path of the base url
+is used if Path is not set. Query of the base url is merged with Query
+*/
+func (o *ClientOptions) Url(baseUrl string) (string, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ws", "http":
+		u.Scheme = "ws"
+	case "wss", "https":
+		u.Scheme = "wss"
+	default:
+		return "", ErrorInvalidUrl
+	}
+	if u.Host == "" {
+		return "", ErrorInvalidUrl
+	}
+
+	path := o.Path
+	if path == "" && u.Path != "" && u.Path != "/" {
+		path = u.Path
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", ErrorInvalidPath
+	}
+	u.Path = path
+	u.RawPath = ""
+
+	query := u.Query()
+	for key, values := range o.Query {
+		query[key] = values
+	}
+	query.Set("transport", "websocket")
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+/*
+*
+Get options of the next connection attempt with params returned by Refresh
+*/
+func (o ClientOptions) refresh(ctx context.Context, lastErr error) (ClientOptions, error) {
+	if o.Refresh == nil {
+		return o, nil
+	}
+
+	params, err := o.Refresh(ctx, lastErr)
+	if err != nil {
+		return o, err
+	}
+
+	if params.Header != nil {
+		o.Header = websocket.MergeHeader(o.Header, params.Header)
+	}
+	if params.Query != nil {
+		query := url.Values{}
+		for key, values := range o.Query {
+			query[key] = values
+		}
+		for key, values := range params.Query {
+			query[key] = values
+		}
+		o.Query = query
+	}
+	if params.Auth != nil {
+		o.Auth = params.Auth
+	}
+
+	return o, nil
+}
+
+func (o *ClientOptions) getTransport() websocket.Transport {
+	if o.Transport == nil {
+		return websocket.GetDefaultWebsocketTransport()
+	}
+
+	return o.Transport
+}
+
+/*
+*
+Set path the server is mounted on, requests to other paths are answered with 404.
+Trailing slash is optional, any path is accepted if not set
+*/
+func (s *Server) SetPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return ErrorInvalidPath
+	}
+
+	s.path = path
+	return nil
+}
+
+func (s *Server) matchPath(r *http.Request) bool {
+	if s.path == "" {
+		return true
+	}
+
+	return strings.TrimSuffix(r.URL.Path, "/") == strings.TrimSuffix(s.path, "/")
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"github.com/ugorji/go/codec"
+	"strconv"
+)
+
+// create and configure Handle
+var (
+	mh codec.MsgpackHandle
+)
+
+/*
+*
+Encode socket.io packet as text, without the engine.io message prefix
+*/
+func EncodeText(msg *MsgPack) string {
+	// {
+	//  "type": 3,
+	//  "nsp": "/admin",
+	//  "data": [],
+	//  "id": 456
+	// }
+	// is encoded to 3/admin,456[]
+	event := strconv.Itoa(msg.Type)
+	ackId := strconv.Itoa(msg.Id)
+	data, _ := utils.Json.Marshal(&msg.Data)
+
+	// sending ack res or sending ack req
+	if msg.Type == ACK || msg.Id >= 0 {
+		return event + ackId + string(data)
+	}
+
+	return event + string(data)
+}
+
+/*
+*
+Encode socket.io packet with msgpack, without the engine.io message prefix
+*/
+func EncodeBinary(msg *MsgPack) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := codec.NewEncoder(&buf, &mh)
+	err := enc.Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+*
+Decode msgpack socket.io packet, returns it's json representation
+*/
+func DecodeBinary(data []byte) (string, error) {
+	var m = MsgPack{
+		Id: -1,
+	}
+	dec := codec.NewDecoderBytes(data, &mh)
+	err := dec.Decode(&m)
+	if err != nil {
+		return "", err
+	}
+
+	return utils.Json.MarshalToString(&m)
+}
@@ -0,0 +1,195 @@
+package shadiaosocketio
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	HeaderForwarded = "Forwarded"
+	HeaderRealIp    = "X-Real-IP"
+)
+
+var (
+	ErrorInvalidProxy           = errors.New("invalid trusted proxy, ip or cidr expected")
+	ErrorInvalidForwardedHeader = errors.New("invalid forwarding header, Forwarded, X-Forwarded-For or X-Real-IP expected")
+)
+
+/*
+*
+Set addresses of proxies which forwarding header is trusted, ips or cidrs e.g. "10.0.0.0/8".
+Without trusted proxies forwarding headers are ignored and client address is the remote address
+*/
+func (s *Server) SetTrustedProxies(proxies ...string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return ErrorInvalidProxy
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return ErrorInvalidProxy
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	s.trustedProxies = prefixes
+	return nil
+}
+
+/*
+*
+Set forwarding header written by trusted proxies: HeaderForwarded, HeaderForward or HeaderRealIp,
+X-Forwarded-For by default. Other forwarding headers are ignored, as clients may send them
+through proxies which don't overwrite them
+*/
+func (s *Server) SetForwardedHeader(header string) error {
+	header = http.CanonicalHeaderKey(header)
+	switch header {
+	case HeaderForwarded, HeaderForward, http.CanonicalHeaderKey(HeaderRealIp):
+	default:
+		return ErrorInvalidForwardedHeader
+	}
+
+	s.forwardedHeader = header
+	return nil
+}
+
+func (s *Server) getForwardedHeader() string {
+	if s.forwardedHeader == "" {
+		return HeaderForward
+	}
+
+	return s.forwardedHeader
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+*
+Get client address resolved through trusted proxies, invalid if the remote address can't be parsed
+*/
+func (c *Channel) ClientAddr() netip.Addr {
+	return c.clientAddr
+}
+
+/*
+*
+Get forwarding chain of the connection from the client to the server: addresses of the trusted forwarding
+header as sent, followed by the remote address. Untrusted clients may put anything in the header
+*/
+func (c *Channel) ProxyChain() []string {
+	return c.proxyChain
+}
+
+/*
+*
+Build forwarding chain of the request and walk it from the right, skipping trusted proxies.
+The first untrusted address is the client, if the chain is exhausted the leftmost address is
+*/
+func (s *Server) resolveClientAddr(remoteAddr string, r *http.Request) (netip.Addr, []string) {
+	chain := []string{remoteAddr}
+	if r != nil {
+		chain = append(forwardingChain(r.Header, s.getForwardedHeader()), remoteAddr)
+	}
+
+	client := parseChainAddr(remoteAddr)
+	if len(s.trustedProxies) == 0 {
+		return client, chain
+	}
+
+	for i := len(chain) - 2; i >= 0; i-- {
+		if !client.IsValid() || !s.isTrustedProxy(client) {
+			break
+		}
+
+		// obfuscated or broken hop, the last proxy is the best known address
+		addr := parseChainAddr(chain[i])
+		if !addr.IsValid() {
+			break
+		}
+		client = addr
+	}
+
+	return client, chain
+}
+
+/*
+*
+Get addresses of the forwarding header, values of repeated headers are joined in order
+*/
+func forwardingChain(header http.Header, name string) []string {
+	chain := make([]string, 0)
+
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if name == HeaderForwarded {
+				chain = append(chain, forwardedFor(element))
+				continue
+			}
+			chain = append(chain, strings.TrimSpace(element))
+		}
+	}
+
+	return chain
+}
+
+/*
+*
+Get "for" parameter of Forwarded header element, e.g. for="[2001:db8::1]:4711";proto=https
+*/
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.EqualFold(key, "for") {
+			continue
+		}
+
+		return strings.Trim(value, "\"")
+	}
+
+	return ""
+}
+
+/*
+*
+Parse address of the chain with optional port, ipv6 may be in brackets
+*/
+func parseChainAddr(value string) netip.Addr {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap()
+	}
+
+	host, _, err := net.SplitHostPort(value)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
@@ -0,0 +1,199 @@
+package shadiaosocketio
+
+import (
+	"net/http"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseChainAddr(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{"192.0.2.1:4711", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:4711", "2001:db8::1"},
+		{"unknown", ""},
+		{"_hidden", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got := parseChainAddr(tt.value)
+		if tt.want == "" {
+			if got.IsValid() {
+				t.Errorf("parseChainAddr(%q) = %v, want invalid", tt.value, got)
+			}
+			continue
+		}
+		if got != netip.MustParseAddr(tt.want) {
+			t.Errorf("parseChainAddr(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolveClientAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		header  string
+		values  map[string][]string
+		remote  string
+		want    string
+		chain   []string
+	}{
+		{
+			name:   "no trusted proxies",
+			values: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			remote: "10.0.0.1:5000",
+			want:   "10.0.0.1",
+			chain:  []string{"198.51.100.1", "10.0.0.1:5000"},
+		},
+		{
+			name:    "untrusted remote",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			remote:  "203.0.113.1:5000",
+			want:    "203.0.113.1",
+			chain:   []string{"198.51.100.1", "203.0.113.1:5000"},
+		},
+		{
+			name:    "trusted proxy",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			remote:  "10.0.0.1:5000",
+			want:    "198.51.100.1",
+			chain:   []string{"198.51.100.1", "10.0.0.1:5000"},
+		},
+		{
+			name:    "spoofed left of untrusted hop",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1, 10.0.0.2"}},
+			remote:  "10.0.0.1:5000",
+			want:    "198.51.100.1",
+			chain:   []string{"1.2.3.4", "198.51.100.1", "10.0.0.2", "10.0.0.1:5000"},
+		},
+		{
+			name:    "repeated headers",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}},
+			remote:  "10.0.0.1:5000",
+			want:    "198.51.100.1",
+			chain:   []string{"198.51.100.1", "10.0.0.2", "10.0.0.1:5000"},
+		},
+		{
+			name:    "chain of trusted proxies only",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			remote:  "10.0.0.1:5000",
+			want:    "10.0.0.3",
+			chain:   []string{"10.0.0.3", "10.0.0.2", "10.0.0.1:5000"},
+		},
+		{
+			name:    "broken hop",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage"}},
+			remote:  "10.0.0.1:5000",
+			want:    "10.0.0.1",
+			chain:   []string{"198.51.100.1", "garbage", "10.0.0.1:5000"},
+		},
+		{
+			name:    "forwarded ignored by default",
+			trusted: []string{"10.0.0.0/8"},
+			values: map[string][]string{
+				"Forwarded":       {"for=1.2.3.4"},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			remote: "10.0.0.1:5000",
+			want:   "198.51.100.1",
+			chain:  []string{"198.51.100.1", "10.0.0.1:5000"},
+		},
+		{
+			name:    "forwarded",
+			trusted: []string{"10.0.0.0/8", "2001:db8::/32"},
+			header:  HeaderForwarded,
+			values: map[string][]string{
+				"Forwarded":       {`for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`},
+				"X-Forwarded-For": {"1.2.3.4"},
+			},
+			remote: "10.0.0.1:5000",
+			want:   "198.51.100.1",
+			chain:  []string{"198.51.100.1", "[2001:db8::1]:4711", "10.0.0.1:5000"},
+		},
+		{
+			name:    "obfuscated forwarded",
+			trusted: []string{"10.0.0.0/8"},
+			header:  HeaderForwarded,
+			values:  map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}},
+			remote:  "10.0.0.1:5000",
+			want:    "10.0.0.2",
+			chain:   []string{"_hidden", "10.0.0.2", "10.0.0.1:5000"},
+		},
+		{
+			name:    "real ip",
+			trusted: []string{"10.0.0.1"},
+			header:  HeaderRealIp,
+			values: map[string][]string{
+				"X-Real-Ip":       {"198.51.100.1"},
+				"X-Forwarded-For": {"1.2.3.4"},
+			},
+			remote: "10.0.0.1:5000",
+			want:   "198.51.100.1",
+			chain:  []string{"198.51.100.1", "10.0.0.1:5000"},
+		},
+		{
+			name:    "ipv4 mapped remote",
+			trusted: []string{"10.0.0.0/8"},
+			values:  map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			remote:  "[::ffff:10.0.0.1]:5000",
+			want:    "198.51.100.1",
+			chain:   []string{"198.51.100.1", "[::ffff:10.0.0.1]:5000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(nil)
+			if err := s.SetTrustedProxies(tt.trusted...); err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				if err := s.SetForwardedHeader(tt.header); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := &http.Request{Header: http.Header{}}
+			for key, values := range tt.values {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			got, chain := s.resolveClientAddr(tt.remote, r)
+			if got != netip.MustParseAddr(tt.want) {
+				t.Errorf("client = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(chain, tt.chain) {
+				t.Errorf("chain = %q, want %q", chain, tt.chain)
+			}
+		})
+	}
+}
+
+func TestSetForwardedHeader(t *testing.T) {
+	s := NewServer(nil)
+	for _, header := range []string{HeaderForwarded, HeaderForward, HeaderRealIp, "x-forwarded-for"} {
+		if err := s.SetForwardedHeader(header); err != nil {
+			t.Errorf("SetForwardedHeader(%q) = %v", header, err)
+		}
+	}
+	if err := s.SetForwardedHeader("X-Client-IP"); err != ErrorInvalidForwardedHeader {
+		t.Errorf("SetForwardedHeader(X-Client-IP) = %v, want %v", err, ErrorInvalidForwardedHeader)
+	}
+}
@@ -0,0 +1,108 @@
+package shadiaosocketio
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+var (
+	ErrorHandlerPanic = errors.New("internal error")
+)
+
+/*
+*
+Panic recovered while decoding or handling incoming packet, or rejected packet,
+passed to OnError handler: server.On(OnError, func(c *Channel, err *HandlerError) {})
+*/
+type HandlerError struct {
+	Channel *Channel
+	// Event is empty if the panic happened before the event name was parsed
+	Event   string
+	Payload []byte
+	// Err is set for rejected packets, Panic and Stack for recovered panics
+	Err   error
+	Panic interface{}
+	Stack []byte
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("socket.io event %q rejected: %v", e.Event, e.Err)
+	}
+
+	return fmt.Sprintf("socket.io handler panic, event %q: %v", e.Event, e.Panic)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+/*
+*
+Answer acks of panicked handlers with AckError, so the caller doesn't wait for the timeout
+*/
+func (m *methods) SetRecoverAck(enabled bool) {
+	m.recoverAck = enabled
+}
+
+/*
+*
+Deferred by packet processing, recovers panic and reports it to OnError handler
+*/
+func (m *methods) recoverEvent(c *Channel, event string, ackId int, payload []byte) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	herr := &HandlerError{
+		Channel: c,
+		Event:   event,
+		Payload: payload,
+		Panic:   r,
+		Stack:   debug.Stack(),
+	}
+	m.reportError(c, herr)
+
+	if m.recoverAck && ackId >= 0 {
+		m.sendAckError(c, ackId, ErrorHandlerPanic)
+	}
+}
+
+/*
+*
+Report rejected event to OnError handler and answer its ack with the error
+*/
+func (m *methods) rejectEvent(c *Channel, event string, ackId int, payload []byte, err error) {
+	m.reportError(c, &HandlerError{
+		Channel: c,
+		Event:   event,
+		Payload: payload,
+		Err:     err,
+	})
+
+	if ackId >= 0 {
+		m.sendAckError(c, ackId, err)
+	}
+}
+
+func (m *methods) reportError(c *Channel, herr *HandlerError) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("socket.io error handler panic: ", r)
+		}
+	}()
+
+	if _, ok := m.findMethod(OnError); !ok {
+		if herr.Err != nil {
+			log.Println(herr.Error())
+		} else {
+			log.Println(herr.Error(), "\n", string(herr.Stack))
+		}
+		return
+	}
+
+	m.callLoopEvent(c, OnError, herr)
+}
@@ -1,7 +1,9 @@
 package shadiaosocketio
 
 import (
+	"context"
 	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/engineio"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
 	"log"
 	"time"
@@ -9,7 +11,7 @@ import (
 
 var (
 	ErrorSendTimeout     = errors.New("timeout")
-	ErrorSocketOverflood = errors.New("socket overflood")
+	ErrorSocketOverflood = engineio.ErrorSocketOverflood
 )
 
 /*
@@ -27,17 +29,25 @@ func send(c *Channel, msg *protocol.Message) error {
 		return nil
 	}
 
-	out := protocol.GetMsgPacket(msg)
+	return c.sendPacket(protocol.GetMsgPacket(msg))
+}
 
-	if len(c.out) == queueBufferSize {
-		return ErrorSocketOverflood
+/*
+*
+Send message packet to socket, waits for room in outgoing queue until ctx is done
+*/
+func sendContext(ctx context.Context, c *Channel, msg *protocol.Message) error {
+	if !c.IsAlive() {
+		return nil
 	}
 
-	c.out <- out
-
-	return nil
+	return c.sendPacketContext(ctx, protocol.GetMsgPacket(msg))
 }
 
+/*
+*
+Emit event, returns ErrorSocketOverflood if outgoing queue is full
+*/
 func (c *Channel) Emit(method string, args ...interface{}) error {
 	msg := &protocol.Message{
 		Type:   protocol.EVENT,
@@ -50,29 +60,37 @@ func (c *Channel) Emit(method string, args ...interface{}) error {
 	return send(c, msg)
 }
 
-func (c *Channel) Ack(method string, timeout time.Duration, args ...interface{}) (interface{}, error) {
+/*
+*
+Emit event, blocks until there is room in outgoing queue or ctx is done
+*/
+func (c *Channel) EmitContext(ctx context.Context, method string, args ...interface{}) error {
 	msg := &protocol.Message{
 		Type:   protocol.EVENT,
-		AckId:  c.ack.getNextId(),
+		AckId:  -1,
 		Method: method,
 		Nsp:    protocol.DefaultNsp,
 		Args:   args,
 	}
 
-	waiter := make(chan interface{})
-	c.ack.addWaiter(msg.AckId, waiter)
+	return sendContext(ctx, c, msg)
+}
 
-	err := send(c, msg)
-	if err != nil {
-		c.ack.removeWaiter(msg.AckId)
-	}
+/*
+*
+Emit event and wait for ack until timeout, error responses are returned as *AckError
+*/
+func (c *Channel) Ack(method string, timeout time.Duration, args ...interface{}) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	select {
-	case result := <-waiter:
-		c.ack.removeWaiter(msg.AckId)
-		return result, nil
-	case <-time.After(timeout):
-		c.ack.removeWaiter(msg.AckId)
+	result, err := c.EmitWithAck(ctx, method, args...).Wait()
+	if err == context.DeadlineExceeded {
 		return nil, ErrorSendTimeout
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
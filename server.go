@@ -1,19 +1,16 @@
 package shadiaosocketio
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/base64"
 	"errors"
-	"fmt"
+	"github.com/Baiguoshuai1/shadiaosocketio/engineio"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
 	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"github.com/Baiguoshuai1/shadiaosocketio/websocket"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/netip"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 const (
@@ -41,7 +38,12 @@ type Server struct {
 	sids     map[string]*Channel
 	sidsLock sync.RWMutex
 
-	tr websocket.Transport
+	trustedProxies  []netip.Prefix
+	forwardedHeader string
+	path            string
+
+	tr  websocket.Transport
+	eio *engineio.Server
 }
 
 /*
@@ -50,18 +52,17 @@ Close current channel
 */
 func (c *Channel) Close() {
 	if c.server != nil {
-		closeChannel(c, &c.server.methods)
+		c.socket.Close()
 	}
 }
 
 /*
 *
-Get ip of socket socket
+Get ip of socket socket, forwarding headers are used only from trusted proxies
 */
 func (c *Channel) Ip() string {
-	forward := c.RequestHeader().Get(HeaderForward)
-	if forward != "" {
-		return forward
+	if c.clientAddr.IsValid() {
+		return c.clientAddr.String()
 	}
 	return c.ip
 }
@@ -269,20 +270,6 @@ func (s *Server) BroadcastToAll(method string, args interface{}) {
 	}
 }
 
-/*
-*
-Generate new id for socket.io connection
-*/
-func generateNewId(custom string) string {
-	hash := fmt.Sprintf("%s %s %n %n", custom, time.Now(), rand.Uint32(), rand.Uint32())
-	buf := bytes.NewBuffer(nil)
-	sum := md5.Sum([]byte(hash))
-	encoder := base64.NewEncoder(base64.URLEncoding, buf)
-	encoder.Write(sum[:])
-	encoder.Close()
-	return buf.String()[:20]
-}
-
 /*
 *
 On connection system handler, store sid
@@ -292,11 +279,6 @@ func onConnectStore(c *Channel) {
 	defer c.server.sidsLock.Unlock()
 
 	c.server.sids[c.Id()] = c
-
-	if c.conn.GetProtocol() == protocol.Protocol4 {
-		// in protocol v4, the server sends a ping, and the client answers with a pong
-		go SchedulePing(c)
-	}
 }
 
 /*
@@ -329,30 +311,22 @@ func deleteSid(c *Channel) {
 	c.server.sidsLock.Lock()
 	defer c.server.sidsLock.Unlock()
 
-	delete(c.server.sids, c.Id())
+	// sid may be reused by a new channel once the socket is closed
+	if c.server.sids[c.Id()] == c {
+		delete(c.server.sids, c.Id())
+	}
 }
 
 func (s *Server) SendOpenSequence(c *Channel) {
-	jsonHdr, err := utils.Json.Marshal(&c.header)
-	if err != nil {
-		panic(err)
-	}
-
-	// GET /socket.io/?EIO=4&transport=polling&t=N8hyd6w
-	// < HTTP/1.1 200 OK
-	// < Content-Type: text/plain; charset=UTF-8
-	// 0{"sid":"lv_VI97HAXpY6yYWAAAC","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000,"maxPayload":1000000}
-	c.out <- protocol.OpenMsg + string(jsonHdr)
-
-	if s.tr.BinaryMessage {
+	if s.tr.GetUseBinaryMessage() {
 		// in protocol v4 & binary msg ps: {"type":0,"data":{"sid":"HWEr440000:1:R1CHyink:shadiao:101"},"nsp":"/","id":0}
-		c.out <- &protocol.MsgPack{
+		c.sendPacket(&protocol.MsgPack{
 			Type: protocol.CONNECT,
 			Nsp:  protocol.DefaultNsp,
 			Data: struct {
 				Sid string `json:"sid"`
 			}{Sid: c.Id()},
-		}
+		})
 	} else {
 		// GET /socket.io/?EIO=4&transport=polling&t=N8hyd7H&sid=lv_VI97HAXpY6yYWAAAC
 		// < HTTP/1.1 200 OK
@@ -368,7 +342,7 @@ func (s *Server) SendOpenSequence(c *Channel) {
 			panic(err)
 		}
 
-		c.out <- protocol.CommonMsg + protocol.OpenMsg + string(marshal)
+		c.socket.Send(protocol.OpenMsg + string(marshal))
 	}
 }
 
@@ -376,32 +350,51 @@ func (s *Server) SendOpenSequence(c *Channel) {
 *
 Setup event loop for given connection
 */
-func (s *Server) SetupEventLoop(conn *websocket.Connection, remoteAddr string,
+func (s *Server) SetupEventLoop(conn websocket.Conn, remoteAddr string,
 	r *http.Request) {
 
-	interval, timeout := conn.PingParams()
-	hdr := Header{
-		Sid:          generateNewId(remoteAddr),
-		Upgrades:     []string{},
-		PingInterval: int(interval / time.Millisecond),
-		PingTimeout:  int(timeout / time.Millisecond),
-	}
+	so := s.eio.NewSocket(conn, r)
 
 	c := &Channel{}
-	c.conn = conn
+	c.sid = so.Id()
 	c.ip = remoteAddr
 	c.request = r
-	c.initChannel()
+	c.clientAddr, c.proxyChain = s.resolveClientAddr(remoteAddr, r)
+	c.handshake = newServerHandshake(r, remoteAddr, so.Protocol())
+	c.initChannel(so, &s.methods)
 
 	c.server = s
-	c.header = hdr
 
+	// in protocol v4 the client connects to the namespace with CONNECT packet
+	so.Start()
+	if so.Protocol() == protocol.Protocol3 {
+		s.connectChannel(c, nil)
+	}
+}
+
+/*
+*
+Channel connected to the namespace, called from the read loop. Answers with CONNECT once
+and queues OnConnection to the serial queue of the channel, so events received meanwhile
+wait for it without blocking the read loop
+*/
+func (s *Server) connectChannel(c *Channel, payload interface{}) {
+	if !atomic.CompareAndSwapInt32(&c.connected, channelIdle, channelConnecting) {
+		return
+	}
+
+	c.handshake.Auth = parseAuth(payload)
 	s.SendOpenSequence(c)
 
-	go inLoop(c, &s.methods)
-	go outLoop(c, &s.methods)
+	task := func() {
+		defer atomic.CompareAndSwapInt32(&c.connected, channelConnecting, channelConnected)
+		defer s.recoverEvent(c, OnConnection, -1, nil)
 
-	s.callLoopEvent(c, OnConnection)
+		s.callLoopEvent(c, OnConnection)
+	}
+	if c.enqueue(task) {
+		go c.drain()
+	}
 }
 
 /*
@@ -412,8 +405,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for key, el := range s.headers {
 		w.Header().Set(key, el)
 	}
-	for key, el := range s.tr.Cors.AllowedHeaders {
-		w.Header().Set(key, el)
+
+	if !s.matchPath(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// cross origin requests are checked and preflights are answered before the transport
+	if ct, ok := s.tr.(websocket.CorsTransport); ok && ct.GetCors().Handle(w, r) {
+		return
 	}
 
 	conn, err := s.tr.HandleConnection(w, r)
@@ -448,17 +448,27 @@ func (s *Server) AmountOfRooms() int64 {
 	return int64(len(s.channels))
 }
 
+/*
+*
+Set generator of socket ids, ids are unique among live sockets
+*/
+func (s *Server) SetIdGenerator(f func(r *http.Request) string) {
+	s.eio.SetIdGenerator(f)
+}
+
 func (s *Server) AddHeader(name string, value string) {
 	s.headers[name] = value
 }
 
 func (s *Server) UpdateTransport(tr websocket.Transport) {
 	s.tr = tr
+	s.eio.UpdateTransport(tr)
 }
 
 func NewServer(tr websocket.Transport) *Server {
 	s := Server{}
 	s.tr = tr
+	s.eio = engineio.NewServer(tr)
 	s.headers = make(map[string]string)
 	s.channels = make(map[string]map[*Channel]struct{})
 	s.rooms = make(map[*Channel]map[string]struct{})
@@ -0,0 +1,118 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"reflect"
+	"time"
+)
+
+var (
+	ErrorAckCallback = errors.New("ack callback must be a function with error as the first arg")
+)
+
+/*
+*
+Emitter waiting for ack no longer than timeout, see Channel.Timeout
+*/
+type TimeoutEmitter struct {
+	c       *Channel
+	timeout time.Duration
+}
+
+/*
+*
+Set ack timeout of the next emit:
+
+	c.Timeout(5*time.Second).Emit("ready?", arg, func(err error, answer string) {})
+*/
+func (c *Channel) Timeout(timeout time.Duration) *TimeoutEmitter {
+	return &TimeoutEmitter{c: c, timeout: timeout}
+}
+
+/*
+*
+Emit event, the last arg may be ack callback func(err error, responses ...T) called
+with responses decoded into its arg types, or with ErrorSendTimeout when there is no ack
+*/
+func (e *TimeoutEmitter) Emit(method string, args ...interface{}) error {
+	if len(args) == 0 {
+		return e.c.Emit(method, args...)
+	}
+
+	cb := reflect.ValueOf(args[len(args)-1])
+	if cb.Kind() != reflect.Func {
+		return e.c.Emit(method, args...)
+	}
+
+	cbType := cb.Type()
+	if cbType.NumIn() == 0 || cbType.In(0) != errorType || cbType.IsVariadic() {
+		return ErrorAckCallback
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	e.c.EmitWithAck(ctx, method, args[:len(args)-1]...).Then(func(result []interface{}, err error) {
+		cancel()
+		if err == context.DeadlineExceeded {
+			err = ErrorSendTimeout
+		}
+
+		callAckCallback(cb, result, err)
+	})
+
+	return nil
+}
+
+func callAckCallback(cb reflect.Value, result []interface{}, err error) {
+	cbType := cb.Type()
+
+	in := make([]reflect.Value, cbType.NumIn())
+	for i := 1; i < len(in); i++ {
+		in[i] = reflect.Zero(cbType.In(i))
+		if err != nil || i > len(result) {
+			continue
+		}
+
+		v, decodeErr := decodeAckValue(cbType.In(i), result[i-1])
+		if decodeErr != nil {
+			err = decodeErr
+			break
+		}
+		in[i] = v
+	}
+
+	if err != nil {
+		for i := 1; i < len(in); i++ {
+			in[i] = reflect.Zero(cbType.In(i))
+		}
+		in[0] = reflect.ValueOf(&err).Elem()
+	} else {
+		in[0] = reflect.Zero(errorType)
+	}
+
+	cb.Call(in)
+}
+
+/*
+*
+Decode ack value, raw json in text mode or decoded value in binary mode, into given type
+*/
+func decodeAckValue(t reflect.Type, v interface{}) (reflect.Value, error) {
+	data, err := ackValueJson(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if t == rawMessageType {
+		return reflect.ValueOf(json.RawMessage(data)), nil
+	}
+
+	ptr := reflect.New(t)
+	if err := utils.Json.Unmarshal(data, ptr.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return ptr.Elem(), nil
+}
@@ -0,0 +1,63 @@
+package shadiaosocketio
+
+import (
+	"context"
+	"errors"
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+)
+
+var (
+	ErrorAckNoResult = errors.New("ack has no result")
+)
+
+/*
+*
+Server and Client
+*/
+type registry interface {
+	On(method string, f interface{}) error
+}
+
+/*
+*
+Register typed handler of the event, request is decoded from the first argument
+and response is sent back in ack. Non-nil error is sent as AckError instead of response.
+ctx is the context of the event, see Use and SetEventTimeout
+*/
+func Handle[Req, Resp any](r registry, event string, f func(ctx context.Context, c *Channel, req Req) (Resp, error)) error {
+	return r.On(event, f)
+}
+
+/*
+*
+Emit event with typed request and wait for typed response until ctx is done,
+response is decoded the same way in text and binary modes
+*/
+func EmitAck[Req, Resp any](c *Channel, ctx context.Context, event string, req Req) (Resp, error) {
+	var resp Resp
+
+	result, err := c.EmitWithAck(ctx, event, req).Wait()
+	if err != nil {
+		return resp, err
+	}
+
+	err = decodeAckResult(result, &resp)
+	return resp, err
+}
+
+/*
+*
+Decode the first value of ack, values are raw json in text mode and decoded values in binary mode
+*/
+func decodeAckResult(values []interface{}, resp interface{}) error {
+	if len(values) == 0 {
+		return ErrorAckNoResult
+	}
+
+	data, err := ackValueJson(values[0])
+	if err != nil {
+		return err
+	}
+
+	return utils.Json.Unmarshal(data, resp)
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	maxPooledBufferSize = 1024 * 64
+)
+
+var (
+	readBuffers = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+/*
+*
+Read whole message with pooled buffer, the result is copied once to the slice
+of exact size which is owned by the caller
+*/
+func ReadMessage(r io.Reader) ([]byte, error) {
+	buf := readBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			buf.Reset()
+			readBuffers.Put(buf)
+		}
+	}()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+
+	return data, nil
+}
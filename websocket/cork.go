@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	maxCorkBufferSize = 1024 * 64
+)
+
+var (
+	ErrorNotHijacker = errors.New("response does not implement http.Hijacker")
+)
+
+/*
+*
+net.Conn which holds writes in memory while corked,
+so frames of a batch reach the socket with a single write
+*/
+type corkConn struct {
+	net.Conn
+
+	lock   sync.Mutex
+	corked bool
+	buf    []byte
+}
+
+func (c *corkConn) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.corked {
+		c.buf = append(c.buf, p...)
+		return len(p), nil
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *corkConn) cork() {
+	c.lock.Lock()
+	c.corked = true
+	c.lock.Unlock()
+}
+
+/*
+*
+Write everything held since cork, big buffers are released to keep idle connections cheap
+*/
+func (c *corkConn) flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.corked = false
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	_, err := c.Conn.Write(c.buf)
+	if cap(c.buf) > maxCorkBufferSize {
+		c.buf = nil
+	} else {
+		c.buf = c.buf[:0]
+	}
+
+	return err
+}
+
+/*
+*
+ResponseWriter wrapping hijacked connection into corkConn
+*/
+type corkResponseWriter struct {
+	http.ResponseWriter
+
+	conn *corkConn
+}
+
+func (w *corkResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrorNotHijacker
+	}
+
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.conn = &corkConn{Conn: conn}
+	return w.conn, brw, nil
+}
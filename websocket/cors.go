@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"github.com/Baiguoshuai1/shadiaosocketio/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAnyOrigin = "*"
+)
+
+var (
+	CorsDefaultMethods = []string{http.MethodGet, http.MethodPost}
+)
+
+/*
+*
+Cross origin settings of the transport, applied to websocket upgrades and http requests.
+Origin is allowed when it matches Origin, one of Origins or AllowOriginFunc,
+without any of them every origin is allowed. Origin "*" of default transports is
+ignored when Origins or AllowOriginFunc are set
+*/
+type Cors struct {
+	Origin string
+	// Origins are exact origins, "*" or wildcard subdomain patterns e.g. "https://*.example.com"
+	Origins         []string
+	AllowOriginFunc func(origin string, r *http.Request) bool
+
+	// AllowedHeaders are set to every response as is
+	AllowedHeaders map[string]string
+	Credentials    bool
+
+	// AllowMethods and AllowHeaders answer preflight requests, requested headers are allowed
+	// when AllowHeaders is empty. MaxAge is how long browsers may cache the preflight result
+	AllowMethods  []string
+	AllowHeaders  []string
+	ExposeHeaders []string
+	MaxAge        time.Duration
+
+	// RequireOrigin rejects requests without Origin header, non browser clients usually don't send it
+	RequireOrigin bool
+}
+
+/*
+*
+Transport with cross origin settings, headers are set and preflights are answered by the server
+before HandleConnection, the transport only checks the origin
+*/
+type CorsTransport interface {
+	GetCors() *Cors
+}
+
+/*
+*
+Checks request origin against allowed ones, requests without origin are allowed unless RequireOrigin is set
+*/
+func (c *Cors) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return !c.RequireOrigin
+	}
+
+	return c.AllowsOrigin(origin, r)
+}
+
+/*
+*
+Checks if origin is allowed by the settings
+*/
+func (c *Cors) AllowsOrigin(origin string, r *http.Request) bool {
+	if c.anyOrigin() {
+		return true
+	}
+
+	if c.Origin != "" && c.Origin != corsAnyOrigin && matchOrigin(c.Origin, origin) {
+		return true
+	}
+	for _, pattern := range c.Origins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return c.AllowOriginFunc != nil && c.AllowOriginFunc(origin, r)
+}
+
+/*
+*
+Set cross origin headers of the response, returns false if origin of the request is not allowed
+*/
+func (c *Cors) SetHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if !c.CheckOrigin(r) {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	header := w.Header()
+	if c.anyOrigin() && !c.Credentials {
+		header.Set("Access-Control-Allow-Origin", corsAnyOrigin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	if c.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
+	}
+
+	return true
+}
+
+/*
+*
+Apply settings to the request, returns true if the response is written: the origin is not allowed
+or the request is a preflight one
+*/
+func (c *Cors) Handle(w http.ResponseWriter, r *http.Request) bool {
+	if !c.SetHeaders(w, r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return true
+	}
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	methods := c.AllowMethods
+	if len(methods) == 0 {
+		methods = CorsDefaultMethods
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if len(c.AllowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+
+	if c.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (c *Cors) anyOrigin() bool {
+	for _, pattern := range c.Origins {
+		if pattern == corsAnyOrigin {
+			return true
+		}
+	}
+	if len(c.Origins) > 0 || c.AllowOriginFunc != nil {
+		return false
+	}
+
+	return c.Origin == "" || c.Origin == corsAnyOrigin
+}
+
+/*
+*
+Match origin with exact origin or pattern with a single "*" in place of subdomains
+*/
+func matchOrigin(pattern, origin string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return utils.EqualASCIIFold(pattern, origin)
+	}
+
+	prefix, suffix := strings.ToLower(pattern[:star]), strings.ToLower(pattern[star+1:])
+	origin = strings.ToLower(origin)
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// subdomains only, the wildcard can't take scheme, port or path
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:")
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsAllowsOrigin(t *testing.T) {
+	partner := func(origin string, r *http.Request) bool {
+		return origin == "https://partner.com"
+	}
+
+	tests := []struct {
+		name   string
+		cors   Cors
+		origin string
+		want   bool
+	}{
+		{"no settings", Cors{}, "https://evil.com", true},
+		{"any origin", Cors{Origin: "*"}, "https://evil.com", true},
+		{"exact origin", Cors{Origin: "https://app.com"}, "https://app.com", true},
+		{"other origin", Cors{Origin: "https://app.com"}, "https://evil.com", false},
+		{"origins over any", Cors{Origin: "*", Origins: []string{"https://app.com"}}, "https://evil.com", false},
+		{"origins over any allowed", Cors{Origin: "*", Origins: []string{"https://app.com"}}, "https://app.com", true},
+		{"func over any", Cors{Origin: "*", AllowOriginFunc: partner}, "https://evil.com", false},
+		{"func over any allowed", Cors{Origin: "*", AllowOriginFunc: partner}, "https://partner.com", true},
+		{"any in origins", Cors{Origins: []string{"https://app.com", "*"}}, "https://evil.com", true},
+		{"subdomain", Cors{Origins: []string{"https://*.app.com"}}, "https://eu.app.com", true},
+		{"subdomain port", Cors{Origins: []string{"https://*.app.com"}}, "https://evil.com:1.app.com", false},
+		{"subdomain apex", Cors{Origins: []string{"https://*.app.com"}}, "https://app.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cors.AllowsOrigin(tt.origin, nil); got != tt.want {
+				t.Errorf("AllowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorsHandleVary(t *testing.T) {
+	cors := Cors{Origins: []string{"https://app.com"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/socket.io/", nil)
+	r.Header.Set("Origin", "https://app.com")
+	w := httptest.NewRecorder()
+
+	if cors.Handle(w, r) {
+		t.Fatal("request is handled")
+	}
+	if !cors.CheckOrigin(r) {
+		t.Fatal("origin is not allowed")
+	}
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin" {
+		t.Errorf("Vary = %q, want [Origin]", vary)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", origin)
+	}
+}
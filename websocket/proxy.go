@@ -0,0 +1,306 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	socksVersion      = 5
+	socksAuthNone     = 0
+	socksAuthPassword = 2
+	socksConnect      = 1
+	socksAddrIPv4     = 1
+	socksAddrDomain   = 3
+	socksAddrIPv6     = 4
+)
+
+var (
+	ErrorProxyScheme = errors.New("proxy scheme is not supported, http, https or socks5 expected")
+	ErrorProxyFailed = errors.New("proxy refused the connection")
+)
+
+/*
+*
+Dials network connections, e.g. net.Dialer.DialContext
+*/
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+/*
+*
+Returns proxy url for the request, nil url means direct connection. http.ProxyFromEnvironment
+and http.ProxyURL can be used
+*/
+type ProxyFunc func(r *http.Request) (*url.URL, error)
+
+/*
+*
+Get proxy url for websocket url, ws and wss schemes are passed to proxy func as http and https
+*/
+func ProxyForUrl(proxy ProxyFunc, rawUrl string) (*url.URL, error) {
+	if proxy == nil {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	return proxy(&http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}})
+}
+
+/*
+*
+Dial addr through the proxy, http and https proxies are asked with CONNECT,
+socks5 and socks5h ones with SOCKS5. Hostname of addr is resolved locally for socks5
+and by the proxy for socks5h. TLS config, e.g. of the transport, is used for https proxies
+with server name of the proxy. User info of the url is used for authentication
+*/
+func DialProxy(ctx context.Context, proxy *url.URL, dial DialFunc, tlsConfig *tls.Config,
+	network, addr string) (net.Conn, error) {
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	var defaultPort string
+	switch proxy.Scheme {
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	case "socks5", "socks5h":
+		defaultPort = "1080"
+	default:
+		return nil, ErrorProxyScheme
+	}
+
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), defaultPort)
+	}
+
+	conn, err := dial(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tunnel := conn
+	switch proxy.Scheme {
+	case "https":
+		tlsConn := tls.Client(conn, proxyTLSConfig(tlsConfig, proxy))
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			tunnel, err = httpConnect(tlsConn, proxy, addr)
+		}
+	case "http":
+		tunnel, err = httpConnect(conn, proxy, addr)
+	default:
+		err = socksConnectAddr(ctx, conn, proxy, addr, proxy.Scheme == "socks5")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_ = tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+/*
+*
+Copy of TLS config for the proxy connection, CONNECT is sent over http/1.1
+*/
+func proxyTLSConfig(tlsConfig *tls.Config, proxy *url.URL) *tls.Config {
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	cfg.ServerName = proxy.Hostname()
+	cfg.NextProtos = nil
+
+	return cfg
+}
+
+/*
+*
+Bytes read after CONNECT response belong to the tunnel
+*/
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(p)
+	}
+
+	return c.Conn.Read(p)
+}
+
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrorProxyFailed, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+func socksConnectAddr(ctx context.Context, conn net.Conn, proxy *url.URL, addr string, resolve bool) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	if resolve && net.ParseIP(host) == nil {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		host = ips[0].IP.String()
+		for _, ip := range ips {
+			if ip.IP.To4() != nil {
+				host = ip.IP.String()
+				break
+			}
+		}
+	}
+
+	method := byte(socksAuthNone)
+	if proxy.User != nil {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion || reply[1] != method {
+		return ErrorProxyFailed
+	}
+
+	if method == socksAuthPassword {
+		password, _ := proxy.User.Password()
+		user := proxy.User.Username()
+		if len(user) > 255 || len(password) > 255 {
+			return ErrorProxyFailed
+		}
+
+		auth := []byte{1, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return ErrorProxyFailed
+		}
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socksAddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socksAddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return ErrorProxyFailed
+		}
+		req = append(req, socksAddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// version, reply, reserved, address type, then the bound address
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("%w: socks reply %d", ErrorProxyFailed, head[1])
+	}
+
+	var size int
+	switch head[3] {
+	case socksAddrIPv4:
+		size = net.IPv4len
+	case socksAddrIPv6:
+		size = net.IPv6len
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, head[:1]); err != nil {
+			return err
+		}
+		size = int(head[0])
+	default:
+		return ErrorProxyFailed
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
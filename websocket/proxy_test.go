@@ -0,0 +1,359 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+*
+Echo server, the target of proxied connections
+*/
+func newEchoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(b, a)
+		b.Close()
+	}()
+	wg.Wait()
+}
+
+/*
+*
+CONNECT proxy, requests without expected credentials are refused
+*/
+func connectHandler(auth string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "connect expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != auth {
+			http.Error(w, "auth required", http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		_, _ = rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		_ = rw.Flush()
+		pipe(conn, target)
+	})
+}
+
+type socksRequest struct {
+	addrType byte
+	host     string
+}
+
+/*
+*
+SOCKS5 proxy accepting user "user" with password "pass" when auth is set,
+requested addresses are sent to requests
+*/
+func newSocksServer(t *testing.T, auth bool, requests chan<- socksRequest) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	serve := func(conn net.Conn) error {
+		r := bufio.NewReader(conn)
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return err
+		}
+		methods := make([]byte, head[1])
+		if _, err := io.ReadFull(r, methods); err != nil {
+			return err
+		}
+
+		method := byte(socksAuthNone)
+		if auth {
+			method = socksAuthPassword
+		}
+		if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+			return err
+		}
+
+		if auth {
+			if _, err := io.ReadFull(r, head); err != nil {
+				return err
+			}
+			user := make([]byte, head[1])
+			if _, err := io.ReadFull(r, user); err != nil {
+				return err
+			}
+			size, _ := r.ReadByte()
+			pass := make([]byte, size)
+			if _, err := io.ReadFull(r, pass); err != nil {
+				return err
+			}
+			if string(user) != "user" || string(pass) != "pass" {
+				_, _ = conn.Write([]byte{1, 1})
+				return errors.New("bad credentials")
+			}
+			if _, err := conn.Write([]byte{1, 0}); err != nil {
+				return err
+			}
+		}
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return err
+		}
+
+		var host string
+		switch req[3] {
+		case socksAddrIPv4, socksAddrIPv6:
+			ip := make([]byte, net.IPv4len)
+			if req[3] == socksAddrIPv6 {
+				ip = make([]byte, net.IPv6len)
+			}
+			if _, err := io.ReadFull(r, ip); err != nil {
+				return err
+			}
+			host = net.IP(ip).String()
+		case socksAddrDomain:
+			size, _ := r.ReadByte()
+			domain := make([]byte, size)
+			if _, err := io.ReadFull(r, domain); err != nil {
+				return err
+			}
+			host = string(domain)
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(r, port); err != nil {
+			return err
+		}
+		requests <- socksRequest{addrType: req[3], host: host}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			_, _ = conn.Write([]byte{socksVersion, 5, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+			return err
+		}
+		if _, err := conn.Write([]byte{socksVersion, 0, 0, socksAddrIPv4, 127, 0, 0, 1, 0, 0}); err != nil {
+			target.Close()
+			return err
+		}
+
+		pipe(conn, target)
+		return nil
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = serve(conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func checkEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echo = %q, want ping", buf)
+	}
+}
+
+func TestDialProxyHttp(t *testing.T) {
+	target := newEchoServer(t)
+	proxy := httptest.NewServer(connectHandler("Basic dXNlcjpwYXNz"))
+	defer proxy.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	proxyUrl.User = url.UserPassword("user", "pass")
+	conn, err := DialProxy(ctx, proxyUrl, nil, nil, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	proxyUrl.User = url.UserPassword("user", "wrong")
+	if _, err := DialProxy(ctx, proxyUrl, nil, nil, "tcp", target); !errors.Is(err, ErrorProxyFailed) {
+		t.Errorf("err = %v, want %v", err, ErrorProxyFailed)
+	}
+}
+
+func TestDialProxyHttps(t *testing.T) {
+	target := newEchoServer(t)
+	proxy := httptest.NewTLSServer(connectHandler(""))
+	defer proxy.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	if _, err := DialProxy(ctx, proxyUrl, nil, nil, "tcp", target); err == nil {
+		t.Fatal("certificate of the proxy is not verified")
+	}
+
+	// root of the test certificate, as the transport TLSConfig would have it
+	tlsConfig := proxy.Client().Transport.(*http.Transport).TLSClientConfig
+	conn, err := DialProxy(ctx, proxyUrl, nil, tlsConfig, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+
+	conn, err = DialProxy(ctx, proxyUrl, nil, &tls.Config{InsecureSkipVerify: true}, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEcho(t, conn)
+}
+
+func TestDialProxySocks(t *testing.T) {
+	target := newEchoServer(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	tests := []struct {
+		scheme   string
+		auth     bool
+		addr     string
+		addrType byte
+		host     string
+	}{
+		{"socks5", false, target, socksAddrIPv4, "127.0.0.1"},
+		{"socks5", true, target, socksAddrIPv4, "127.0.0.1"},
+		{"socks5", false, net.JoinHostPort("localhost", port), socksAddrIPv4, "127.0.0.1"},
+		{"socks5h", false, net.JoinHostPort("localhost", port), socksAddrDomain, "localhost"},
+		{"socks5h", true, net.JoinHostPort("localhost", port), socksAddrDomain, "localhost"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheme+" "+tt.addr, func(t *testing.T) {
+			requests := make(chan socksRequest, 1)
+			proxyUrl := &url.URL{Scheme: tt.scheme, Host: newSocksServer(t, tt.auth, requests)}
+			if tt.auth {
+				proxyUrl.User = url.UserPassword("user", "pass")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := DialProxy(ctx, proxyUrl, nil, nil, "tcp", tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEcho(t, conn)
+
+			req := <-requests
+			if req.addrType != tt.addrType || req.host != tt.host {
+				t.Errorf("requested %d %q, want %d %q", req.addrType, req.host, tt.addrType, tt.host)
+			}
+		})
+	}
+}
+
+func TestDialProxySocksAuthFailed(t *testing.T) {
+	proxyUrl := &url.URL{
+		Scheme: "socks5",
+		Host:   newSocksServer(t, true, make(chan socksRequest, 1)),
+		User:   url.UserPassword("user", "wrong"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := DialProxy(ctx, proxyUrl, nil, nil, "tcp", "127.0.0.1:1"); !errors.Is(err, ErrorProxyFailed) {
+		t.Errorf("err = %v, want %v", err, ErrorProxyFailed)
+	}
+}
+
+func TestProxyForUrl(t *testing.T) {
+	proxy := func(r *http.Request) (*url.URL, error) {
+		return &url.URL{Scheme: "http", Host: r.URL.Scheme + ".proxy:3128"}, nil
+	}
+
+	for rawUrl, want := range map[string]string{
+		"ws://example.com/socket.io/":  "http.proxy:3128",
+		"wss://example.com/socket.io/": "https.proxy:3128",
+	} {
+		got, err := ProxyForUrl(proxy, rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Host != want {
+			t.Errorf("ProxyForUrl(%q) = %q, want %q", rawUrl, got.Host, want)
+		}
+	}
+
+	if got, err := ProxyForUrl(nil, "ws://example.com/"); got != nil || err != nil {
+		t.Errorf("ProxyForUrl(nil) = %v, %v", got, err)
+	}
+}
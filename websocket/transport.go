@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+/*
+*
+Frame level connection, implemented by every transport
+*/
+type Conn interface {
+	// ReadFrame blocks until the next frame, messageType is TextMessage or BinaryMessage
+	ReadFrame() (messageType int, data []byte, err error)
+	WriteFrame(messageType int, data []byte) error
+	Close() error
+
+	RemoteAddr() net.Addr
+	LocalAddr() net.Addr
+}
+
+/*
+*
+Event driven connection, frames are pushed to the handler by the transport
+instead of being read in a loop, so idle connections hold no goroutine.
+Handler is called with non-nil err once, when the connection fails
+*/
+type PollConn interface {
+	Conn
+
+	Poll(handler func(messageType int, data []byte, err error)) error
+}
+
+/*
+*
+Single frame of a batch
+*/
+type Frame struct {
+	MessageType int
+	Data        []byte
+}
+
+/*
+*
+Connection able to write several frames with a single flush
+*/
+type BatchConn interface {
+	Conn
+
+	WriteFrames(frames []Frame) error
+}
+
+/*
+*
+Transport creates connections on client and server side,
+WebsocketTransport based on gorilla/websocket is the default one
+*/
+type Transport interface {
+	Connect(url string) (Conn, error)
+	HandleConnection(w http.ResponseWriter, r *http.Request) (Conn, error)
+	Serve(w http.ResponseWriter, r *http.Request)
+
+	GetProtocol() int
+	GetUseBinaryMessage() bool
+	GetQueueSize() int
+	WriteBatchParams() (size int, latency time.Duration)
+	PingParams() (interval, timeout time.Duration)
+}
+
+/*
+*
+Transport able to connect with context and additional request header,
+the header is merged into the request header of the transport
+*/
+type ContextTransport interface {
+	ConnectContext(ctx context.Context, url string, header http.Header) (Conn, error)
+}
+
+/*
+*
+Merge additional header into the request header of the transport, values of header win
+*/
+func MergeHeader(base, header http.Header) http.Header {
+	merged := base.Clone()
+	if merged == nil {
+		merged = http.Header{}
+	}
+	for key, values := range header {
+		merged[http.CanonicalHeaderKey(key)] = values
+	}
+
+	return merged
+}
+
+/*
+*
+Client side dial params shared by transports, can be embedded
+*/
+type DialParams struct {
+	// Proxy returns proxy of the connection, e.g. http.ProxyFromEnvironment
+	Proxy ProxyFunc
+	// NetDialContext dials network connections of the client, e.g. with local address or to unix socket
+	NetDialContext DialFunc
+	// HandshakeTimeout limits dialing and websocket handshake
+	HandshakeTimeout time.Duration
+	// Jar sends and stores cookies of websocket handshake
+	Jar http.CookieJar
+}
+
+/*
+*
+Get dial func of the client connection to url, it's going through the proxy if one is set for url.
+TLS config of the transport is used for https proxies
+*/
+func (p *DialParams) ContextDialer(rawUrl string, tlsConfig *tls.Config) (DialFunc, error) {
+	dial := p.NetDialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	proxy, err := ProxyForUrl(p.Proxy, rawUrl)
+	if err != nil || proxy == nil {
+		return dial, err
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return DialProxy(ctx, proxy, dial, tlsConfig, network, addr)
+	}, nil
+}
+
+/*
+*
+engine.io and socket.io params shared by transports,
+can be embedded to implement part of Transport
+*/
+type Params struct {
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	Protocol      int
+	BinaryMessage bool
+
+	// QueueSize is the size of outgoing queue of every socket
+	QueueSize int
+
+	// WriteBatchSize is the max amount of queued packets flushed at once, WriteBatchLatency
+	// is how long the writer may wait for more packets before flushing an incomplete batch
+	WriteBatchSize    int
+	WriteBatchLatency time.Duration
+}
+
+func (p *Params) GetProtocol() int {
+	return p.Protocol
+}
+
+func (p *Params) GetUseBinaryMessage() bool {
+	return p.BinaryMessage
+}
+
+func (p *Params) GetQueueSize() int {
+	return p.QueueSize
+}
+
+func (p *Params) WriteBatchParams() (size int, latency time.Duration) {
+	return p.WriteBatchSize, p.WriteBatchLatency
+}
+
+func (p *Params) PingParams() (interval, timeout time.Duration) {
+	return p.PingInterval, p.PingTimeout
+}
@@ -1,18 +1,14 @@
 package websocket
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"github.com/Baiguoshuai1/shadiaosocketio/protocol"
 	"github.com/Baiguoshuai1/shadiaosocketio/utils"
 	"github.com/gorilla/websocket"
-	"github.com/ugorji/go/codec"
-	"io"
 	"net"
 	"net/http"
-	"reflect"
-	"strconv"
 	"time"
 )
 
@@ -24,19 +20,24 @@ const (
 	WsDefaultReceiveTimeout = 60 * time.Second
 	WsDefaultSendTimeout    = 60 * time.Second
 	WsDefaultBufferSize     = 1024 * 32
+	WsDefaultQueueSize      = 10000
+	WsDefaultWriteBatchSize = 64
+)
 
-	maxRecordReadBytes  = 1024 * 1024 * 1024
-	maxRecordWriteBytes = 1024 * 1024 * 1024
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
 )
 
 const (
-	DecodeErrCode       = 102
-	ParseOpenMsgCode    = 103
-	QueueBufferSizeCode = 104
-	WriteBufferErrCode  = 105
-	BinaryMsgErrCode    = 106
-	BadBufferErrCode    = 107
-	PacketWrongErrCode  = 108
+	DecodeErrCode          = 102
+	ParseOpenMsgCode       = 103
+	QueueBufferSizeCode    = 104
+	WriteBufferErrCode     = 105
+	BinaryMsgErrCode       = 106
+	BadBufferErrCode       = 107
+	PacketWrongErrCode     = 108
+	DispatcherOverflowCode = 109
 )
 
 var (
@@ -45,22 +46,21 @@ var (
 	ErrorPacketWrong       = errors.New("wrong packet type error")
 	ErrorMethodNotAllowed  = errors.New("method not allowed")
 	ErrorHttpUpgradeFailed = errors.New("http upgrade failed")
-)
-
-// create and configure Handle
-var (
-	mh codec.MsgpackHandle
+	ErrorOriginNotAllowed  = errors.New("origin not allowed")
 )
 
 type CloseError struct {
 	websocket.CloseError
 }
 
+/*
+*
+gorilla/websocket based Conn
+*/
 type Connection struct {
-	socket     *websocket.Conn
-	transport  *Transport
-	writeBytes int
-	readBytes  int
+	socket    *websocket.Conn
+	transport *WebsocketTransport
+	cork      *corkConn
 }
 
 func (wsc *Connection) RemoteAddr() net.Addr {
@@ -71,80 +71,37 @@ func (wsc *Connection) LocalAddr() net.Addr {
 	return wsc.socket.LocalAddr()
 }
 
-func (wsc *Connection) GetProtocol() int {
-	return wsc.transport.Protocol
-}
-
-func (wsc *Connection) GetUseBinaryMessage() bool {
-	return wsc.transport.BinaryMessage
-}
-
-func (wsc *Connection) GetReadBytes() int {
-	v := wsc.readBytes
-	wsc.readBytes = 0
-	return v
-}
-
-func (wsc *Connection) GetWriteBytes() int {
-	v := wsc.writeBytes
-	wsc.writeBytes = 0
-	return v
-}
-
-func (wsc *Connection) GetMessage() (message string, err error) {
+func (wsc *Connection) ReadFrame() (messageType int, data []byte, err error) {
 	err = wsc.socket.SetReadDeadline(time.Now().Add(wsc.transport.ReceiveTimeout))
 	if err != nil {
-		return "", err
+		return 0, nil, err
 	}
 
-	msgType, reader, err := wsc.socket.NextReader()
+	messageType, reader, err := wsc.socket.NextReader()
 	if err != nil {
-		return "", err
+		return 0, nil, err
 	}
 
-	var data []byte
-	data, err = io.ReadAll(reader)
+	data, err = ReadMessage(reader)
 	if err != nil {
-		return "", &websocket.CloseError{
+		return 0, nil, &websocket.CloseError{
 			Code: BadBufferErrCode,
 			Text: err.Error(),
 		}
 	}
 
-	utils.Debug("[GetMessage]", data)
-	if wsc.readBytes > maxRecordReadBytes {
-		wsc.readBytes = 0
-	}
-	wsc.readBytes += len(data)
-	return wsc.decodeMessage(data, msgType)
+	utils.Debug("[ReadFrame]", data)
+	return messageType, data, nil
 }
 
-func (wsc *Connection) WriteMessage(message interface{}) error {
-	utils.Debug("[WriteMessage]", message)
+func (wsc *Connection) WriteFrame(messageType int, data []byte) error {
+	utils.Debug("[WriteFrame]", data)
 
 	err := wsc.socket.SetWriteDeadline(time.Now().Add(wsc.transport.SendTimeout))
 	if err != nil {
 		return err
 	}
 
-	var data []byte
-
-	messageType := websocket.TextMessage
-	if reflect.TypeOf(message).Kind() == reflect.String {
-		data = []byte(message.(string))
-	} else {
-		if wsc.transport.BinaryMessage {
-			messageType = websocket.BinaryMessage
-		} else {
-			messageType = websocket.TextMessage
-		}
-
-		data, err = wsc.encodeMessage(message.(*protocol.MsgPack), messageType)
-		if err != nil {
-			return err
-		}
-	}
-
 	writer, err := wsc.socket.NextWriter(messageType)
 	if err != nil {
 		return err
@@ -152,127 +109,51 @@ func (wsc *Connection) WriteMessage(message interface{}) error {
 	if _, err := writer.Write(data); err != nil {
 		return err
 	}
-	if err := writer.Close(); err != nil {
-		return err
-	}
-	if wsc.writeBytes > maxRecordWriteBytes {
-		wsc.writeBytes = 0
-	}
-	wsc.writeBytes += len(data)
-	return nil
+	return writer.Close()
 }
 
-func (wsc *Connection) decodeMessage(data []byte, messageType int) (string, error) {
-	if messageType == websocket.TextMessage {
-		utils.Debug("[decodeMessage]", string(data))
-		return string(data), nil
-	}
-	prefix := ""
-
-	if wsc.transport.Protocol == protocol.Protocol3 {
-		prefix = strconv.Itoa(int(data[0]))
-		data = data[1:]
-	}
-
-	var m = protocol.MsgPack{
-		Id: -1,
-	}
-	dec := codec.NewDecoderBytes(data, &mh)
-	err := dec.Decode(&m)
-	if err != nil {
-		return "", &websocket.CloseError{
-			Code: DecodeErrCode,
-			Text: err.Error(),
-		}
-	}
-
-	msg, err := utils.Json.MarshalToString(&m)
-	if err != nil {
-		return "", &websocket.CloseError{
-			Code: DecodeErrCode,
-			Text: err.Error(),
+/*
+*
+Write frames of the batch to the connection buffer and flush them at once
+*/
+func (wsc *Connection) WriteFrames(frames []Frame) error {
+	if wsc.cork == nil {
+		for _, frame := range frames {
+			if err := wsc.WriteFrame(frame.MessageType, frame.Data); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
-	utils.Debug("[decodeMessage]", prefix+msg)
-	return prefix + msg, nil
-}
-
-func (wsc *Connection) encodeMessage(msg *protocol.MsgPack, messageType int) ([]byte, error) {
-	// {
-	//  "type": 3,
-	//  "nsp": "/admin",
-	//  "data": [],
-	//  "id": 456
-	// }
-	// is encoded to 3/admin,456[]
-
-	packet := ""
-	if messageType == websocket.TextMessage {
-		// Engine.IO Flag
-		prefix := protocol.CommonMsg
-		// Socket.IO Flag
-		event := strconv.Itoa(msg.Type)
-		ackId := strconv.Itoa(msg.Id)
-		data, _ := utils.Json.Marshal(&msg.Data)
-
-		// sending ack res or sending ack req
-		if msg.Type == protocol.ACK || msg.Id >= 0 {
-			packet = prefix + event + ackId + string(data)
-		} else {
-			packet = prefix + event + string(data)
+	wsc.cork.cork()
+	for _, frame := range frames {
+		if err := wsc.WriteFrame(frame.MessageType, frame.Data); err != nil {
+			wsc.cork.flush()
+			return err
 		}
-
-		utils.Debug("[encodeMessage]", packet)
-		return []byte(packet), nil
-	}
-
-	buf := bytes.Buffer{}
-	enc := codec.NewEncoder(&buf, &mh)
-	err := enc.Encode(msg)
-	if err != nil {
-		return nil, err
-	}
-
-	prefix, err := strconv.ParseUint(protocol.CommonMsg, 10, 8)
-	if err != nil {
-		return nil, err
-	}
-
-	bf := make([]uint8, 0, buf.Len())
-	bf = append(bf, uint8(prefix))
-	bf = append(bf, buf.Bytes()...)
-
-	if wsc.transport.Protocol == protocol.Protocol4 {
-		bf = bf[1:]
 	}
 
-	return bf, nil
-}
-
-func (wsc *Connection) Close() {
-	wsc.socket.Close()
+	return wsc.cork.flush()
 }
 
-func (wsc *Connection) PingParams() (interval, timeout time.Duration) {
-	return wsc.transport.PingInterval, wsc.transport.PingTimeout
+func (wsc *Connection) Close() error {
+	return wsc.socket.Close()
 }
 
-type Cors struct {
-	Origin         string
-	AllowedHeaders map[string]string
-	Credentials    bool
-}
+/*
+*
+gorilla/websocket based Transport
+*/
+type WebsocketTransport struct {
+	Params
+	DialParams
 
-type Transport struct {
-	PingInterval   time.Duration
-	PingTimeout    time.Duration
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
-
-	Protocol      int
-	BufferSize    int
-	BinaryMessage bool
+	BufferSize     int
+	// EnableCompression negotiates permessage-deflate on client and server side
+	EnableCompression bool
 
 	UnsecureTLS bool
 	TLSConfig   *tls.Config
@@ -281,77 +162,103 @@ type Transport struct {
 	Cors          Cors
 }
 
-func (wst *Transport) Connect(url string) (conn *Connection, err error) {
+func (wst *WebsocketTransport) Connect(url string) (Conn, error) {
+	return wst.ConnectContext(context.Background(), url, nil)
+}
+
+func (wst *WebsocketTransport) ConnectContext(ctx context.Context, url string, header http.Header) (Conn, error) {
 	tlsCfg := wst.TLSConfig
 	if tlsCfg == nil {
 		tlsCfg = &tls.Config{InsecureSkipVerify: wst.UnsecureTLS}
 	}
-	dialer := websocket.Dialer{TLSClientConfig: tlsCfg}
-	socket, _, err := dialer.Dial(url, wst.RequestHeader)
+	dial, err := wst.ContextDialer(url, tlsCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Connection{socket, wst, 0, 0}, nil
+	var cork *corkConn
+	dialer := websocket.Dialer{
+		TLSClientConfig:   tlsCfg,
+		HandshakeTimeout:  wst.HandshakeTimeout,
+		EnableCompression: wst.EnableCompression,
+		Jar:               wst.Jar,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			cork = &corkConn{Conn: conn}
+			return cork, nil
+		},
+	}
+	socket, _, err := dialer.DialContext(ctx, url, MergeHeader(wst.RequestHeader, header))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Connection{socket: socket, transport: wst, cork: cork}, nil
 }
 
-func (wst *Transport) HandleConnection(
-	w http.ResponseWriter, r *http.Request) (conn *Connection, err error) {
+func (wst *WebsocketTransport) HandleConnection(
+	w http.ResponseWriter, r *http.Request) (Conn, error) {
+
+	for key, el := range wst.Cors.AllowedHeaders {
+		w.Header().Set(key, el)
+	}
 
 	if r.Method != "GET" {
 		return nil, ErrorMethodNotAllowed
 	}
-
-	upgrade := &websocket.Upgrader{
-		ReadBufferSize:  wst.BufferSize,
-		WriteBufferSize: wst.BufferSize,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	if !wst.Cors.CheckOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, ErrorOriginNotAllowed
 	}
 
-	if wst.Cors.Origin != "*" {
-		upgrade.CheckOrigin = func(r *http.Request) bool {
-			if len(wst.Cors.Origin) == 0 {
-				return true
-			}
-			if len(r.Header["Origin"]) == 0 {
-				return true
-			}
-
-			return utils.EqualASCIIFold(wst.Cors.Origin, r.Header.Get("Origin"))
-		}
-
-		w.Header().Set("Access-Control-Allow-Credentials", strconv.FormatBool(wst.Cors.Credentials))
+	upgrade := &websocket.Upgrader{
+		ReadBufferSize:    wst.BufferSize,
+		WriteBufferSize:   wst.BufferSize,
+		CheckOrigin:       wst.Cors.CheckOrigin,
+		EnableCompression: wst.EnableCompression,
 	}
 
-	socket, err := upgrade.Upgrade(w, r, nil)
+	cw := &corkResponseWriter{ResponseWriter: w}
+	// hijacked response has only headers passed to the upgrader
+	socket, err := upgrade.Upgrade(cw, r, w.Header())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Connection{socket, wst, 0, 0}, nil
+	return &Connection{socket: socket, transport: wst, cork: cw.conn}, nil
+}
+
+func (wst *WebsocketTransport) GetCors() *Cors {
+	return &wst.Cors
 }
 
 /*
 *
 Websocket connection do not require any additional processing
 */
-func (wst *Transport) Serve(w http.ResponseWriter, r *http.Request) {}
+func (wst *WebsocketTransport) Serve(w http.ResponseWriter, r *http.Request) {}
 
 /*
 *
 Returns websocket connection with default params
 */
-func GetDefaultWebsocketTransport() *Transport {
-	return &Transport{
-		Protocol:       protocol.Protocol4,
-		PingInterval:   WsDefaultPingInterval,
-		PingTimeout:    WsDefaultPingTimeout,
+func GetDefaultWebsocketTransport() *WebsocketTransport {
+	return &WebsocketTransport{
+		Params: Params{
+			Protocol:       protocol.Protocol4,
+			PingInterval:   WsDefaultPingInterval,
+			PingTimeout:    WsDefaultPingTimeout,
+			BinaryMessage:  false,
+			QueueSize:      WsDefaultQueueSize,
+			WriteBatchSize: WsDefaultWriteBatchSize,
+		},
 		ReceiveTimeout: WsDefaultReceiveTimeout,
 		SendTimeout:    WsDefaultSendTimeout,
 		BufferSize:     WsDefaultBufferSize,
-		BinaryMessage:  false,
 		UnsecureTLS:    false,
 		TLSConfig:      nil,
 		Cors: Cors{